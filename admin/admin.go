@@ -0,0 +1,70 @@
+// Package admin serves the operational endpoints (metrics, health and
+// readiness) either on the feed generator's router or, when the feed
+// generator is disabled, on a separate admin port.
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	sloggin "github.com/samber/slog-gin"
+)
+
+type Config struct {
+	Port   int
+	Health Health
+}
+
+// RegisterRoutes adds the admin endpoints to a router. They must be added
+// before any authentication middleware.
+func RegisterRoutes(router gin.IRoutes, health Health) {
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/healthz", health.handleHealthz)
+	router.GET("/readyz", health.handleReadyz)
+	if health.Consumer != nil {
+		router.GET("/readyz/consumer", health.handleConsumerReadyz)
+	}
+}
+
+// RunAdminServer serves only the admin endpoints until ctx is done.
+func RunAdminServer(ctx context.Context, config Config) error {
+	logger := slog.With("component", "admin")
+
+	router := gin.New()
+	router.Use(sloggin.New(logger))
+	router.Use(gin.Recovery())
+	RegisterRoutes(router, config.Health)
+
+	logger.Info("starting server", "port", config.Port)
+
+	srv := http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Port),
+		Handler: router,
+	}
+	serverError := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverError <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		logger.Info("shutdown")
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("failed to shut down admin server: %v", err)
+		}
+		return nil
+	case err := <-serverError:
+		return fmt.Errorf("admin server error: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"jetstream-feed-generator/consumer"
+	"jetstream-feed-generator/store"
+)
+
+// pingTimeout bounds the database check made by the health endpoints.
+const pingTimeout = 2 * time.Second
+
+// Health holds what the health endpoints check.
+type Health struct {
+	Store store.Store
+	// Consumer is nil when the consumer doesn't run in this process.
+	Consumer *consumer.Status
+	// Serving is set when this process serves feeds. Its readiness then
+	// doesn't depend on the consumer, since feeds are served from the
+	// database while the consumer catches up or reconnects;
+	// /readyz/consumer reports on the consumer instead.
+	Serving bool
+	// MaxLag is the consumer lag above which the service reports not ready.
+	MaxLag time.Duration
+}
+
+type readyResponse struct {
+	Ready    bool            `json:"ready"`
+	Reasons  []string        `json:"reasons,omitempty"`
+	DB       string          `json:"db"`
+	Consumer *consumerStatus `json:"consumer,omitempty"`
+}
+
+type consumerStatus struct {
+	Connected bool         `json:"connected"`
+	Cursor    int64        `json:"cursor"`
+	LagS      float64      `json:"lag_s"`
+	Feeds     []feedStatus `json:"feeds"`
+}
+
+type feedStatus struct {
+	Name   string  `json:"name"`
+	Cursor int64   `json:"cursor"`
+	LagS   float64 `json:"lag_s"`
+}
+
+// handleHealthz reports whether the process is alive and the database is
+// reachable.
+func (h Health) handleHealthz(c *gin.Context) {
+	if err := h.pingDB(c.Request.Context()); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "error", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz additionally reports whether the process is ready to do its
+// job: to serve feeds, which only needs the database, or, in a process that
+// only runs the consumer, to consume Jetstream.
+func (h Health) handleReadyz(c *gin.Context) {
+	h.ready(c, !h.Serving)
+}
+
+// handleConsumerReadyz reports whether the consumer is ready, whatever else
+// the process runs.
+func (h Health) handleConsumerReadyz(c *gin.Context) {
+	h.ready(c, true)
+}
+
+// ready responds with the database and consumer status. With checkConsumer,
+// the process is not ready while the consumer is disconnected from
+// Jetstream or lagging by more than MaxLag.
+func (h Health) ready(c *gin.Context, checkConsumer bool) {
+	resp := readyResponse{Ready: true, DB: "ok"}
+	if err := h.pingDB(c.Request.Context()); err != nil {
+		resp.Ready = false
+		resp.DB = err.Error()
+		resp.Reasons = append(resp.Reasons, "database unreachable")
+	}
+
+	if h.Consumer != nil {
+		now := time.Now()
+		cursor := h.Consumer.Cursor()
+		lag := now.Sub(time.UnixMicro(cursor))
+		cs := &consumerStatus{
+			Connected: h.Consumer.Connected(),
+			Cursor:    cursor,
+			LagS:      lag.Seconds(),
+			Feeds:     []feedStatus{},
+		}
+		for name, feedCursor := range h.Consumer.FeedCursors() {
+			cs.Feeds = append(cs.Feeds, feedStatus{
+				Name:   name,
+				Cursor: feedCursor,
+				LagS:   now.Sub(time.UnixMicro(feedCursor)).Seconds(),
+			})
+		}
+		sort.Slice(cs.Feeds, func(i, j int) bool { return cs.Feeds[i].Name < cs.Feeds[j].Name })
+		resp.Consumer = cs
+
+		if checkConsumer && !cs.Connected {
+			resp.Ready = false
+			resp.Reasons = append(resp.Reasons, "not connected to Jetstream")
+		}
+		if checkConsumer && h.MaxLag > 0 && lag > h.MaxLag {
+			resp.Ready = false
+			resp.Reasons = append(resp.Reasons, "consumer lag exceeds "+h.MaxLag.String())
+		}
+	}
+
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, resp)
+}
+
+func (h Health) pingDB(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return h.Store.Ping(ctx)
+}
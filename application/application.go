@@ -2,23 +2,27 @@ package application
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 
+	"jetstream-feed-generator/admin"
 	confpkg "jetstream-feed-generator/config"
 	"jetstream-feed-generator/consumer"
 	dbpkg "jetstream-feed-generator/db"
 	"jetstream-feed-generator/feedgen"
-
-	_ "modernc.org/sqlite"
+	"jetstream-feed-generator/store"
+	"jetstream-feed-generator/store/postgres"
+	"jetstream-feed-generator/store/sqlite"
 )
 
-func Run(config confpkg.Config) error {
+func setupLogger(config confpkg.Config, w io.Writer) *slog.Logger {
 	var logger *slog.Logger
 	handlerOptions := slog.HandlerOptions{}
 	logLevel := slog.LevelVar{}
@@ -30,53 +34,96 @@ func Run(config confpkg.Config) error {
 		}
 	}
 	if config.LogFormat == "json" {
-		logger = slog.New(slog.NewJSONHandler(os.Stdout, &handlerOptions))
+		logger = slog.New(slog.NewJSONHandler(w, &handlerOptions))
 	} else {
 		if config.LogFormat != "" && config.LogFormat != "text" {
 			err = fmt.Errorf("invalid log format: %s", config.LogFormat)
 		}
-		logger = slog.New(slog.NewTextHandler(os.Stdout, &handlerOptions))
+		logger = slog.New(slog.NewTextHandler(w, &handlerOptions))
 	}
 	slog.SetDefault(logger)
 	if err != nil {
 		logger.Warn("failed to parse log options", "error", err)
 	}
+	return logger
+}
+
+func Run(config confpkg.Config) error {
+	logger := setupLogger(config, os.Stdout)
 	confpkg.LogViperEnvVars(config, "", logger)
-	err = config.Validate()
+	err := config.Validate()
 	if err != nil {
 		return fmt.Errorf("failed to parse config: %v", err)
 	}
-	db, err := sql.Open("sqlite", "file:"+config.DBFilename+"?cache=shared&_journal_mode=WAL")
+	db, err := openStore(config)
 	if err != nil {
 		return fmt.Errorf("failed to open db: %v", err)
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-			logger.Error("failed to close db", "error", err)
-		}
-	}(db)
+	defer closeStore(logger, db)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if _, err := db.ExecContext(ctx, dbpkg.SchemaSQL); err != nil {
-		return fmt.Errorf("failed to set up database schema: %v", err)
+	if err := checkSchema(ctx, logger, config, db); err != nil {
+		return err
 	}
 	var wg sync.WaitGroup
+	// stopErr is set if the failure policy stopped the consumer.
+	var stopErr error
+
+	var consumerFeeds []consumer.FeedConfig
+	var feedgenFeeds []feedgen.FeedConfig
+	for _, fc := range config.EnabledFeeds() {
+		consumerFeeds = append(consumerFeeds, fc.ConsumerFeed())
+		feedgenFeeds = append(feedgenFeeds, fc.FeedgenFeed())
+	}
+
+	health := admin.Health{
+		Store:   db,
+		MaxLag:  config.Consumer.ReadyMaxLag,
+		Serving: config.Feedgen.Enabled,
+	}
 
 	if config.Consumer.Enabled {
+		health.Consumer = consumer.NewStatus()
 		consumerConfig := consumer.Config{
-			JetstreamURL: config.Consumer.JetstreamURL,
-			StartCursor:  config.Consumer.StartCursor,
-			DB:           db,
+			JetstreamURLs:    config.JetstreamURLs(),
+			FailoverRewind:   config.Consumer.FailoverRewind,
+			GiveUpAfter:      config.Consumer.GiveUpAfter,
+			StallTimeout:     config.Consumer.StallTimeout,
+			ProbeInterval:    config.Consumer.ProbeInterval,
+			StartCursor:      config.Consumer.StartCursor,
+			BackfillLookback: config.Consumer.BackfillLookback,
+			Workers:          config.Consumer.Workers,
+			BatchSize:        config.Consumer.BatchSize,
+			FlushInterval:    config.Consumer.FlushInterval,
+			StatsInterval:    config.Consumer.StatsInterval,
+			PruneInterval:    config.Consumer.PruneInterval,
+			PruneBatchSize:   config.Consumer.PruneBatchSize,
+			FailurePolicy:    config.Consumer.FailurePolicy,
+			MaxRetries:       config.Consumer.MaxRetries,
+			Engagement:       config.Consumer.Engagement,
+			Graph:            config.Consumer.Graph,
+			RecordFile:       config.Consumer.RecordFile,
+			Feeds:            consumerFeeds,
+			Store:            db,
+			Status:           health.Consumer,
 		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			if runErr := consumer.RunConsumer(ctx, consumerConfig); runErr != nil {
 				slog.Error("consumer error", "error", runErr)
-				cancel()
+				// The feed generator keeps serving what is already in the
+				// database; /readyz/consumer reports it as disconnected.
+				// Unless the failure policy stopped the consumer, in which
+				// case the process exits so it is restarted.
+				if errors.Is(runErr, consumer.ErrStopped) {
+					stopErr = runErr
+					cancel()
+				} else if !config.Feedgen.Enabled {
+					cancel()
+				}
 			}
 		}()
 	}
@@ -86,8 +133,9 @@ func Run(config confpkg.Config) error {
 			FeedActorDID:    config.Feedgen.FeedActorDID,
 			ServiceEndpoint: config.Feedgen.ServiceEndpoint,
 			Port:            config.Feedgen.Port,
-			FeedNames:       []string{config.FeedName},
-			DB:              db,
+			Feeds:           feedgenFeeds,
+			Store:           db,
+			Health:          health,
 		}
 		wg.Add(1)
 		go func() {
@@ -99,6 +147,23 @@ func Run(config confpkg.Config) error {
 		}()
 	}
 
+	// The feed generator serves the admin endpoints itself; otherwise they
+	// get a server of their own.
+	if !config.Feedgen.Enabled && config.AdminPort != 0 {
+		adminConfig := admin.Config{
+			Port:   config.AdminPort,
+			Health: health,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if runErr := admin.RunAdminServer(ctx, adminConfig); runErr != nil {
+				slog.Error("admin server error", "error", runErr)
+				cancel()
+			}
+		}()
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
@@ -107,5 +172,117 @@ func Run(config confpkg.Config) error {
 	}()
 
 	wg.Wait()
+	return stopErr
+}
+
+func openStore(config confpkg.Config) (store.Store, error) {
+	switch config.DBDriver {
+	case confpkg.DBDriverPostgres:
+		return postgres.Open(config.DBURL)
+	default:
+		return sqlite.Open(config.DBFilename)
+	}
+}
+
+// Migrate applies pending migrations, or prints them if dryRun is set.
+func Migrate(config confpkg.Config, dryRun bool) error {
+	logger := setupLogger(config, os.Stdout)
+	db, err := openStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %v", err)
+	}
+	defer closeStore(logger, db)
+
+	ctx := context.Background()
+	if dryRun {
+		pending, err := db.PendingMigrations(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list pending migrations: %v", err)
+		}
+		if len(pending) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		for _, m := range pending {
+			fmt.Printf("%04d_%s\n", m.Version, m.Name)
+		}
+		return nil
+	}
+
+	applied, err := db.Migrate(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %v", err)
+	}
+	if len(applied) == 0 {
+		logger.Info("database schema is up to date")
+	}
+	logMigrations(logger, applied)
+	return nil
+}
+
+// checkSchema applies pending migrations if AUTO_MIGRATE is set, and
+// otherwise refuses to run against a database that needs them.
+func checkSchema(ctx context.Context, logger *slog.Logger, config confpkg.Config, db store.Store) error {
+	if config.AutoMigrate {
+		applied, err := db.Migrate(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to migrate database: %v", err)
+		}
+		logMigrations(logger, applied)
+		return nil
+	}
+	pending, err := db.PendingMigrations(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list pending migrations: %v", err)
+	}
+	if len(pending) > 0 {
+		names := make([]string, len(pending))
+		for i, m := range pending {
+			names[i] = fmt.Sprintf("%04d_%s", m.Version, m.Name)
+		}
+		return fmt.Errorf("database schema is out of date, pending migrations: %s; run the migrate command or set AUTO_MIGRATE",
+			strings.Join(names, ", "))
+	}
 	return nil
 }
+
+// Replay runs a recording through the configured feeds, writing matches to the
+// configured database.
+func Replay(config confpkg.Config, file string, realtime bool) error {
+	logger := setupLogger(config, os.Stdout)
+	db, err := openStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %v", err)
+	}
+	defer closeStore(logger, db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := checkSchema(ctx, logger, config, db); err != nil {
+		return err
+	}
+
+	var feeds []consumer.FeedConfig
+	for _, fc := range config.EnabledFeeds() {
+		feeds = append(feeds, fc.ConsumerFeed())
+	}
+	return consumer.Replay(ctx, consumer.ReplayConfig{
+		File:      file,
+		Realtime:  realtime,
+		BatchSize: config.Consumer.BatchSize,
+		Feeds:     feeds,
+		Store:     db,
+	})
+}
+
+func closeStore(logger *slog.Logger, db store.Store) {
+	if err := db.Close(); err != nil {
+		logger.Error("failed to close db", "error", err)
+	}
+}
+
+func logMigrations(logger *slog.Logger, applied []dbpkg.Migration) {
+	for _, m := range applied {
+		logger.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+}
@@ -0,0 +1,76 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	confpkg "jetstream-feed-generator/config"
+	"jetstream-feed-generator/consumer"
+)
+
+// ListDeadLetters prints dead letters, oldest first.
+func ListDeadLetters(config confpkg.Config, limit int) error {
+	logger := setupLogger(config, os.Stderr)
+	db, err := openStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %v", err)
+	}
+	defer closeStore(logger, db)
+
+	ctx := context.Background()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDID\tTIME_US\tATTEMPTS\tFAILED AT\tERROR")
+	var afterID int64
+	listed := 0
+	for limit == 0 || listed < limit {
+		pageSize := 100
+		if limit > 0 {
+			pageSize = min(pageSize, limit-listed)
+		}
+		letters, err := db.ListDeadLetters(ctx, afterID, pageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list dead letters: %v", err)
+		}
+		if len(letters) == 0 {
+			break
+		}
+		for _, letter := range letters {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%s\t%s\n", letter.ID, letter.Did, letter.TimeUs, letter.Attempts,
+				letter.FailedAt.UTC().Format(time.RFC3339), letter.Error)
+			afterID = letter.ID
+		}
+		listed += len(letters)
+	}
+	return w.Flush()
+}
+
+// ReprocessDeadLetters runs dead letters through the configured feeds again.
+func ReprocessDeadLetters(config confpkg.Config, limit int) error {
+	logger := setupLogger(config, os.Stdout)
+	db, err := openStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %v", err)
+	}
+	defer closeStore(logger, db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var feeds []consumer.FeedConfig
+	for _, fc := range config.EnabledFeeds() {
+		feeds = append(feeds, fc.ConsumerFeed())
+	}
+	succeeded, failed, err := consumer.ReprocessDeadLetters(ctx, consumer.ReprocessConfig{
+		Limit:     limit,
+		BatchSize: config.Consumer.BatchSize,
+		Feeds:     feeds,
+		Store:     db,
+	})
+	logger.Info("reprocessed dead letters", "succeeded", succeeded, "failed", failed)
+	return err
+}
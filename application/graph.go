@@ -0,0 +1,86 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	confpkg "jetstream-feed-generator/config"
+	"jetstream-feed-generator/graph"
+)
+
+// BootstrapGraph loads the follows and blocks in repo exports (CAR files),
+// replacing those recorded for each repo's account.
+func BootstrapGraph(config confpkg.Config, files []string) error {
+	logger := setupLogger(config, os.Stdout)
+	db, err := openStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %v", err)
+	}
+	defer closeStore(logger, db)
+
+	if !config.Consumer.Graph {
+		logger.Warn("CONSUMER_GRAPH is disabled, so bootstrapped follows and blocks won't be kept up to date")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	g := graph.New(db)
+	for _, file := range files {
+		result, err := bootstrapFile(ctx, g, file)
+		if err != nil {
+			return fmt.Errorf("failed to bootstrap %s: %w", file, err)
+		}
+		logger.Info("bootstrapped graph", "file", file, "did", result.Did,
+			"follows", result.Follows, "blocks", result.Blocks, "skipped", result.Skipped)
+	}
+	return nil
+}
+
+func bootstrapFile(ctx context.Context, g *graph.Graph, file string) (graph.BootstrapResult, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return graph.BootstrapResult{}, err
+	}
+	defer f.Close()
+	return g.Bootstrap(ctx, f)
+}
+
+// InspectGraph prints up to limit of the accounts did follows and blocks and
+// of those that follow and block it.
+func InspectGraph(config confpkg.Config, did string, limit int) error {
+	logger := setupLogger(config, os.Stderr)
+	db, err := openStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %v", err)
+	}
+	defer closeStore(logger, db)
+
+	ctx := context.Background()
+	g := graph.New(db)
+	lists := []struct {
+		relation string
+		list     func(ctx context.Context, did, after string, limit int) ([]string, error)
+	}{
+		{"follows", g.Follows},
+		{"followed by", g.Followers},
+		{"blocks", g.Blocks},
+		{"blocked by", g.BlockedBy},
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RELATION\tDID")
+	for _, l := range lists {
+		dids, err := l.list(ctx, did, "", limit)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %v", l.relation, err)
+		}
+		for _, other := range dids {
+			fmt.Fprintf(w, "%s\t%s\n", l.relation, other)
+		}
+	}
+	return w.Flush()
+}
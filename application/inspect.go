@@ -0,0 +1,121 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	confpkg "jetstream-feed-generator/config"
+	"jetstream-feed-generator/feedgen"
+)
+
+// InspectFeeds prints each feed in the database with its cursor and post
+// count. Logs go to stderr so the output can be piped.
+func InspectFeeds(config confpkg.Config) error {
+	logger := setupLogger(config, os.Stderr)
+	db, err := openStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %v", err)
+	}
+	defer closeStore(logger, db)
+
+	feeds, err := db.ListFeeds(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list feeds: %v", err)
+	}
+	enabled := make(map[string]bool)
+	for _, fc := range config.EnabledFeeds() {
+		enabled[fc.Name] = true
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FEED\tENABLED\tCURSOR\tCURSOR TIME\tLAG\tPOSTS")
+	for _, feed := range feeds {
+		cursor, cursorTime, lag := "-", "-", "-"
+		if feed.Cursor != 0 {
+			t := time.UnixMicro(feed.Cursor)
+			cursor = fmt.Sprint(feed.Cursor)
+			cursorTime = t.UTC().Format(time.RFC3339)
+			lag = time.Since(t).Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\t%d\n", feed.Name, enabled[feed.Name], cursor, cursorTime, lag, feed.Posts)
+	}
+	return w.Flush()
+}
+
+// InspectPage prints a page of a feed as JSON, in the same shape as the
+// getFeedSkeleton response, as seen by viewer (a DID, or empty for an
+// anonymous request).
+func InspectPage(config confpkg.Config, feed, viewer string, limit int64, cursor string) error {
+	logger := setupLogger(config, os.Stderr)
+	db, err := openStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %v", err)
+	}
+	defer closeStore(logger, db)
+
+	// Rank and personalize the feed as configured; feeds missing from the
+	// config are chronological.
+	feedConfig := feedgen.FeedConfig{Name: feed}
+	for _, fc := range config.Feeds {
+		if fc.Name == feed {
+			feedConfig = fc.FeedgenFeed()
+		}
+	}
+	ranking, err := feedgen.NewRanking(feedConfig.Ranking)
+	if err != nil {
+		return err
+	}
+	dbFeed := feedgen.DbFeed{
+		FeedActorDID:  config.Feedgen.FeedActorDID,
+		FeedName:      feed,
+		Store:         db,
+		Ranking:       ranking,
+		HideBlocked:   feedConfig.HideBlocked,
+		FollowingOnly: feedConfig.FollowingOnly,
+	}
+	posts, newCursor, err := dbFeed.GetPage(context.Background(), feed, viewer, limit, cursor)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bsky.FeedGetFeedSkeleton_Output{
+		Feed:   posts,
+		Cursor: newCursor,
+	})
+}
+
+// InspectRecords prints, for each enabled feed, the
+// com.atproto.repo.putRecord request body that publishes its generator
+// record, with its display name and description, to the feed actor's repo.
+func InspectRecords(config confpkg.Config) error {
+	if config.Feedgen.FeedActorDID == "" {
+		return fmt.Errorf("FEEDGEN_FEED_ACTOR_DID is required")
+	}
+	serviceDID, err := feedgen.ServiceDID(config.Feedgen.ServiceEndpoint)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	for _, fc := range config.EnabledFeeds() {
+		err := enc.Encode(atproto.RepoPutRecord_Input{
+			Repo:       config.Feedgen.FeedActorDID,
+			Collection: "app.bsky.feed.generator",
+			Rkey:       fc.Name,
+			Record:     &lexutil.LexiconTypeDecoder{Val: fc.FeedgenFeed().GeneratorRecord(serviceDID, now)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,20 +5,54 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"jetstream-feed-generator/consumer"
+	"jetstream-feed-generator/feedgen"
+	"jetstream-feed-generator/rules"
 	"log/slog"
 	"reflect"
 	"strings"
+	"time"
+)
+
+// Supported values for DB_DRIVER.
+const (
+	DBDriverSQLite   = "sqlite"
+	DBDriverPostgres = "postgres"
 )
 
 type Config struct {
-	DBFilename string `mapstructure:"db_filename"`
-	FeedName   string `mapstructure:"feed_name"`
-	LogLevel   string `mapstructure:"log_level"`
-	LogFormat  string `mapstructure:"log_format"`
-	Consumer   struct {
-		Enabled      bool   `mapstructure:"enabled"`
-		JetstreamURL string `mapstructure:"jetstream_url"`
-		StartCursor  int64  `mapstructure:"start_cursor"`
+	DBDriver   string       `mapstructure:"db_driver"`
+	DBFilename string       `mapstructure:"db_filename"`
+	DBURL      string       `mapstructure:"db_url" log:"redact"`
+	FeedName   string       `mapstructure:"feed_name"`
+	Feeds      []FeedConfig `mapstructure:"feeds"`
+	LogLevel   string       `mapstructure:"log_level"`
+	LogFormat  string       `mapstructure:"log_format"`
+	AdminPort  int          `mapstructure:"admin_port"`
+	// AutoMigrate applies pending migrations at startup; without it the
+	// service refuses to start until they have been applied.
+	AutoMigrate bool `mapstructure:"auto_migrate"`
+	Consumer    struct {
+		Enabled          bool          `mapstructure:"enabled"`
+		JetstreamURL     string        `mapstructure:"jetstream_url"`
+		JetstreamURLs    []string      `mapstructure:"jetstream_urls"`
+		FailoverRewind   time.Duration `mapstructure:"failover_rewind"`
+		GiveUpAfter      time.Duration `mapstructure:"give_up_after"`
+		StallTimeout     time.Duration `mapstructure:"stall_timeout"`
+		ProbeInterval    time.Duration `mapstructure:"probe_interval"`
+		StartCursor      int64         `mapstructure:"start_cursor"`
+		BackfillLookback time.Duration `mapstructure:"backfill_lookback"`
+		Workers          int           `mapstructure:"workers"`
+		BatchSize        int           `mapstructure:"batch_size"`
+		FlushInterval    time.Duration `mapstructure:"flush_interval"`
+		StatsInterval    time.Duration `mapstructure:"stats_interval"`
+		ReadyMaxLag      time.Duration `mapstructure:"ready_max_lag"`
+		Engagement       bool          `mapstructure:"engagement"`
+		Graph            bool          `mapstructure:"graph"`
+		RecordFile       string        `mapstructure:"record_file"`
+		FailurePolicy    string        `mapstructure:"failure_policy"`
+		MaxRetries       int           `mapstructure:"max_retries"`
+		PruneInterval    time.Duration `mapstructure:"prune_interval"`
+		PruneBatchSize   int           `mapstructure:"prune_batch_size"`
 	} `mapstructure:"consumer"`
 	Feedgen struct {
 		Enabled         bool   `mapstructure:"enabled"`
@@ -28,19 +62,164 @@ type Config struct {
 	} `mapstructure:"feedgen"`
 }
 
+// FeedConfig is one entry in the feeds list of the config file.
+type FeedConfig struct {
+	Name string `mapstructure:"name"`
+	// Type selects the feed implementation, e.g. "composer-errors" or "rules".
+	Type        string     `mapstructure:"type"`
+	Enabled     *bool      `mapstructure:"enabled"`
+	DisplayName string     `mapstructure:"display_name"`
+	Description string     `mapstructure:"description"`
+	Rule        rules.Rule `mapstructure:"rule"`
+	// Retention limits the posts kept for the feed; zero values are
+	// unlimited.
+	Retention struct {
+		MaxAge   time.Duration `mapstructure:"max_age"`
+		MaxPosts int64         `mapstructure:"max_posts"`
+	} `mapstructure:"retention"`
+	// HideBlocked and FollowingOnly personalize the feed for the viewer;
+	// see feedgen.DbFeed.
+	HideBlocked   bool `mapstructure:"hide_blocked"`
+	FollowingOnly bool `mapstructure:"following_only"`
+	// Ranking orders the feed's posts when served; see feedgen.RankingConfig.
+	Ranking struct {
+		Type       string        `mapstructure:"type"`
+		Window     time.Duration `mapstructure:"window"`
+		Gravity    float64       `mapstructure:"gravity"`
+		Candidates int64         `mapstructure:"candidates"`
+	} `mapstructure:"ranking"`
+}
+
+// ConsumerFeed returns the consumer's view of the feed.
+func (fc FeedConfig) ConsumerFeed() consumer.FeedConfig {
+	return consumer.FeedConfig{
+		Name: fc.Name,
+		Type: fc.Type,
+		Rule: fc.Rule,
+		Retention: consumer.Retention{
+			MaxAge:   fc.Retention.MaxAge,
+			MaxPosts: fc.Retention.MaxPosts,
+		},
+	}
+}
+
+// FeedgenFeed returns the feed generator's view of the feed.
+func (fc FeedConfig) FeedgenFeed() feedgen.FeedConfig {
+	return feedgen.FeedConfig{
+		Name:        fc.Name,
+		DisplayName: fc.DisplayName,
+		Description: fc.Description,
+		Ranking: feedgen.RankingConfig{
+			Type:       fc.Ranking.Type,
+			Window:     fc.Ranking.Window,
+			Gravity:    fc.Ranking.Gravity,
+			Candidates: fc.Ranking.Candidates,
+		},
+		HideBlocked:   fc.HideBlocked,
+		FollowingOnly: fc.FollowingOnly,
+	}
+}
+
+// IsEnabled reports whether the feed should be served; feeds are enabled
+// unless explicitly disabled.
+func (fc FeedConfig) IsEnabled() bool {
+	return fc.Enabled == nil || *fc.Enabled
+}
+
+// EnabledFeeds returns the feeds to serve. Without a feeds list, FEED_NAME
+// names a single composer-errors feed.
+func (config Config) EnabledFeeds() []FeedConfig {
+	if len(config.Feeds) == 0 {
+		if config.FeedName == "" {
+			return nil
+		}
+		return []FeedConfig{{Name: config.FeedName, Type: consumer.FeedTypeComposerErrors}}
+	}
+	var feeds []FeedConfig
+	for _, fc := range config.Feeds {
+		if fc.IsEnabled() {
+			feeds = append(feeds, fc)
+		}
+	}
+	return feeds
+}
+
+// JetstreamURLs returns the Jetstream instances to connect to, in order of
+// preference. CONSUMER_JETSTREAM_URLS takes precedence over the single
+// CONSUMER_JETSTREAM_URL.
+func (config Config) JetstreamURLs() []string {
+	if len(config.Consumer.JetstreamURLs) > 0 {
+		return config.Consumer.JetstreamURLs
+	}
+	if config.Consumer.JetstreamURL != "" {
+		return []string{config.Consumer.JetstreamURL}
+	}
+	return nil
+}
+
 func (config Config) Validate() error {
 	if !(config.Consumer.Enabled || config.Feedgen.Enabled) {
 		return fmt.Errorf("at least one of CONSUMER_ENABLED or FEEDGEN_ENABLED must be specified")
 	}
-	if config.DBFilename == "" {
-		return fmt.Errorf("DB_FILENAME is required")
+	if err := config.ValidateDB(); err != nil {
+		return err
 	}
-	if config.FeedName == "" {
-		return fmt.Errorf("FEED_NAME is required")
+	if err := config.ValidateFeeds(); err != nil {
+		return err
 	}
 	if config.Consumer.Enabled {
-		if config.Consumer.JetstreamURL == "" {
-			return fmt.Errorf("CONSUMER_JETSTREAM_URL is required")
+		if len(config.JetstreamURLs()) == 0 {
+			return fmt.Errorf("CONSUMER_JETSTREAM_URLS or CONSUMER_JETSTREAM_URL is required")
+		}
+		if config.Consumer.FailoverRewind < 0 {
+			return fmt.Errorf("CONSUMER_FAILOVER_REWIND must not be negative")
+		}
+		if config.Consumer.GiveUpAfter < 0 {
+			return fmt.Errorf("CONSUMER_GIVE_UP_AFTER must not be negative")
+		}
+		if config.Consumer.StallTimeout < 0 {
+			return fmt.Errorf("CONSUMER_STALL_TIMEOUT must not be negative")
+		}
+		if config.Consumer.ProbeInterval < 0 {
+			return fmt.Errorf("CONSUMER_PROBE_INTERVAL must not be negative")
+		}
+		switch config.Consumer.FailurePolicy {
+		case consumer.FailureSkip, consumer.FailureRetry, consumer.FailureStop:
+		default:
+			return fmt.Errorf("CONSUMER_FAILURE_POLICY must be %q, %q or %q",
+				consumer.FailureSkip, consumer.FailureRetry, consumer.FailureStop)
+		}
+		if config.Consumer.MaxRetries < 0 {
+			return fmt.Errorf("CONSUMER_MAX_RETRIES must not be negative")
+		}
+		if config.Consumer.BatchSize <= 0 {
+			return fmt.Errorf("CONSUMER_BATCH_SIZE must be positive")
+		}
+		if config.Consumer.FlushInterval <= 0 {
+			return fmt.Errorf("CONSUMER_FLUSH_INTERVAL must be positive")
+		}
+		if config.Consumer.StatsInterval <= 0 {
+			return fmt.Errorf("CONSUMER_STATS_INTERVAL must be positive")
+		}
+		if config.Consumer.PruneInterval < 0 {
+			return fmt.Errorf("CONSUMER_PRUNE_INTERVAL must not be negative")
+		}
+		if config.Consumer.PruneInterval > 0 && config.Consumer.PruneBatchSize <= 0 {
+			return fmt.Errorf("CONSUMER_PRUNE_BATCH_SIZE must be positive")
+		}
+		if !config.Consumer.Graph {
+			for _, fc := range config.EnabledFeeds() {
+				if fc.HideBlocked || fc.FollowingOnly {
+					return fmt.Errorf("feed %s is personalized, which needs CONSUMER_GRAPH", fc.Name)
+				}
+			}
+		}
+		if !config.Consumer.Engagement {
+			for _, fc := range config.EnabledFeeds() {
+				if fc.FeedgenFeed().Ranking.UsesEngagement() {
+					return fmt.Errorf("feed %s is ranked by engagement, which needs CONSUMER_ENGAGEMENT", fc.Name)
+				}
+			}
 		}
 	}
 	if config.Feedgen.Enabled {
@@ -57,19 +236,86 @@ func (config Config) Validate() error {
 	return nil
 }
 
+// ValidateFeeds checks the feeds list.
+func (config Config) ValidateFeeds() error {
+	if len(config.Feeds) == 0 && config.FeedName == "" {
+		return fmt.Errorf("either feeds or FEED_NAME is required")
+	}
+	feedNames := make(map[string]bool)
+	for i, fc := range config.Feeds {
+		if fc.Name == "" {
+			return fmt.Errorf("feeds[%d]: name is required", i)
+		}
+		if feedNames[fc.Name] {
+			return fmt.Errorf("feeds[%d]: duplicate feed name %q", i, fc.Name)
+		}
+		feedNames[fc.Name] = true
+		if err := fc.ConsumerFeed().Validate(); err != nil {
+			return fmt.Errorf("feeds[%d] (%s): %w", i, fc.Name, err)
+		}
+		if err := fc.FeedgenFeed().Ranking.Validate(); err != nil {
+			return fmt.Errorf("feeds[%d] (%s): %w", i, fc.Name, err)
+		}
+	}
+	if len(config.EnabledFeeds()) == 0 {
+		return fmt.Errorf("no feeds are enabled")
+	}
+	return nil
+}
+
+// ValidateDB checks only the database settings, for commands that don't run
+// the consumer or feed generator.
+func (config Config) ValidateDB() error {
+	switch config.DBDriver {
+	case DBDriverSQLite:
+		if config.DBFilename == "" {
+			return fmt.Errorf("DB_FILENAME is required")
+		}
+	case DBDriverPostgres:
+		if config.DBURL == "" {
+			return fmt.Errorf("DB_URL is required")
+		}
+	default:
+		return fmt.Errorf("DB_DRIVER must be %q or %q", DBDriverSQLite, DBDriverPostgres)
+	}
+	return nil
+}
+
 func setupFlags(cmd *cobra.Command) {
 	flags := cmd.PersistentFlags()
 
 	flags.String("config", "", "YAML config file path")
 
-	flags.String("db_filename", "feeds.sqlite", "Database filename")
-	flags.String("feed_name", "composer-errors", "Feed name")
+	flags.String("db_driver", DBDriverSQLite, "Database driver (sqlite or postgres)")
+	flags.String("db_filename", "feeds.sqlite", "Database filename (sqlite)")
+	flags.String("db_url", "", "Database URL (postgres)")
+	flags.String("feed_name", "composer-errors", "Feed name, used when no feeds list is configured")
 	flags.String("log_level", "INFO", "Log level")
 	flags.String("log_format", "text", "Log format (text or json)")
+	flags.Bool("auto_migrate", false, "Apply pending database migrations at startup instead of refusing to start")
+	flags.Int("admin_port", 9073, "Port for the metrics and health endpoints when the feed generator is disabled (0 to disable)")
 
 	flags.Bool("consumer.enabled", true, "Enable consumer")
 	flags.String("consumer.jetstream_url", consumer.DefaultJetstreamURL, "Jetstream URL")
+	flags.StringSlice("consumer.jetstream_urls", nil, "Jetstream URLs to fail over between, in order of preference (overrides consumer.jetstream_url)")
+	flags.Duration("consumer.failover_rewind", 10*time.Second, "How far to rewind the cursor when failing over to another Jetstream instance")
+	flags.Duration("consumer.give_up_after", 0, "Stop the consumer after reconnecting for this long without receiving events (0 to retry forever)")
+	flags.Duration("consumer.stall_timeout", time.Minute, "Reconnect, failing over if possible, when a Jetstream connection goes this long without events (0 to wait forever)")
+	flags.Duration("consumer.probe_interval", time.Minute, "How often to probe preferred Jetstream instances while connected to a fallback (0 to switch only on failure)")
 	flags.Int64("consumer.start_cursor", 0, "Start cursor position")
+	flags.Duration("consumer.backfill_lookback", 0, "How far back feeds without a saved cursor start reading")
+	flags.Int("consumer.workers", 1, "Number of events to handle in parallel (1 handles events sequentially)")
+	flags.Int("consumer.batch_size", 500, "Number of buffered writes that triggers a flush; event handling waits while four times as many are buffered")
+	flags.Duration("consumer.flush_interval", time.Second, "Checkpoint interval: maximum time writes and cursors are buffered before a flush")
+	flags.Duration("consumer.stats_interval", 5*time.Second, "Interval between stats log lines")
+	flags.Duration("consumer.prune_interval", 10*time.Minute, "Interval between enforcing feed retention policies (0 to disable pruning)")
+	flags.Int("consumer.prune_batch_size", 1000, "Maximum posts deleted per pruning transaction")
+	flags.Bool("consumer.engagement", false, "Ingest likes and reposts to count engagement with posts in feeds ranked by engagement")
+	flags.Bool("consumer.graph", false, "Ingest follows and blocks for personalized feeds")
+	flags.String("consumer.record_file", "", "File to record received events to, for replay (compressed if it ends in .zst)")
+	flags.String("consumer.failure_policy", consumer.FailureRetry, "What to do with events that fail: skip, retry or stop (skip and retry record them as dead letters)")
+	flags.Int("consumer.max_retries", 3, "Retries for failed events, or their failed writes, with the retry failure policy")
+	flags.Duration("consumer.ready_max_lag", 5*time.Minute, "Consumer lag above which /readyz/consumer, and /readyz in a consumer-only process, report not ready (0 to ignore lag)")
 
 	flags.Bool("feedgen.enabled", true, "Enable feed generator")
 	flags.Int("feedgen.port", 9072, "Feed generator port")
@@ -125,26 +371,236 @@ func logStructFields(v reflect.Value, prefix string, logger *slog.Logger) {
 			continue
 		}
 
+		value := v.Field(i).Interface()
+		if field.Tag.Get("log") == "redact" && !v.Field(i).IsZero() {
+			value = "[redacted]"
+		}
 		logger.Info("config value",
-			fullPath, v.Field(i).Interface(),
+			fullPath, value,
 		)
 	}
 }
 
-func Execute(runFn func(Config) error) error {
+// Commands holds the entrypoints run by the command line.
+type Commands struct {
+	// Run starts whichever of the consumer and feed generator are enabled.
+	Run func(Config) error
+	// Migrate applies pending schema migrations, or only lists them if
+	// dryRun is set.
+	Migrate func(cfg Config, dryRun bool) error
+	// InspectFeeds lists the feeds in the database with their cursors and
+	// post counts.
+	InspectFeeds func(Config) error
+	// InspectPage prints a page of a feed as getFeedSkeleton would return it
+	// to viewer.
+	InspectPage func(cfg Config, feed, viewer string, limit int64, cursor string) error
+	// Replay runs a recording of Jetstream events through the feeds.
+	Replay func(cfg Config, file string, realtime bool) error
+	// ListDeadLetters prints up to limit dead letters, or all if limit is 0.
+	ListDeadLetters func(cfg Config, limit int) error
+	// ReprocessDeadLetters runs up to limit dead letters through the feeds
+	// again, or all if limit is 0.
+	ReprocessDeadLetters func(cfg Config, limit int) error
+	// InspectRecords prints the requests that publish the feeds' generator
+	// records.
+	InspectRecords func(Config) error
+	// InspectGraph lists up to limit of each kind of follow and block
+	// recorded to and from an account.
+	InspectGraph func(cfg Config, did string, limit int) error
+	// BootstrapGraph loads the follows and blocks in repo export files.
+	BootstrapGraph func(cfg Config, files []string) error
+}
+
+func loadConfig() (Config, error) {
+	var cfg Config
+	if err := viper.Unmarshal(&cfg); err != nil {
+		return cfg, fmt.Errorf("unmarshal config: %w", err)
+	}
+	return cfg, nil
+}
+
+// runWith returns a RunE that loads and validates the config, lets adjust
+// override it first, and passes it to run.
+func runWith(adjust func(*Config), run func(Config) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if adjust != nil {
+			adjust(&cfg)
+		}
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		return run(cfg)
+	}
+}
+
+// runWithDB is like runWith for commands that only use the database.
+func runWithDB(run func(Config) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if err := cfg.ValidateDB(); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		return run(cfg)
+	}
+}
+
+func Execute(commands Commands) error {
 	cmd := &cobra.Command{
-		Use: "jetstream-feed-generator",
+		Use:  "jetstream-feed-generator",
+		Args: cobra.NoArgs,
+		RunE: runWith(nil, commands.Run),
+	}
+
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run only the feed generator",
+		Args:  cobra.NoArgs,
+		RunE: runWith(func(cfg *Config) {
+			cfg.Consumer.Enabled = false
+			cfg.Feedgen.Enabled = true
+		}, commands.Run),
+	}
+
+	consumeCmd := &cobra.Command{
+		Use:   "consume",
+		Short: "Run only the Jetstream consumer",
+		Args:  cobra.NoArgs,
+		RunE: runWith(func(cfg *Config) {
+			cfg.Consumer.Enabled = true
+			cfg.Feedgen.Enabled = false
+		}, commands.Run),
+	}
+
+	var dryRun bool
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply pending database migrations without starting the service",
+		Args:  cobra.NoArgs,
+		RunE: runWithDB(func(cfg Config) error {
+			return commands.Migrate(cfg, dryRun)
+		}),
+	}
+	migrateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print pending migrations without applying them")
+
+	inspectCmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "Inspect the feeds stored in the database",
+	}
+	inspectFeedsCmd := &cobra.Command{
+		Use:   "feeds",
+		Short: "List feeds with their cursors and post counts",
+		Args:  cobra.NoArgs,
+		RunE:  runWithDB(commands.InspectFeeds),
+	}
+	var pageLimit int64
+	var pageCursor, pageViewer string
+	inspectPageCmd := &cobra.Command{
+		Use:   "page <feed>",
+		Short: "Print a page of a feed as getFeedSkeleton returns it",
+		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var cfg Config
-			if err := viper.Unmarshal(&cfg); err != nil {
-				return fmt.Errorf("unmarshal config: %w", err)
+			return runWithDB(func(cfg Config) error {
+				return commands.InspectPage(cfg, args[0], pageViewer, pageLimit, pageCursor)
+			})(cmd, args)
+		},
+	}
+	inspectPageCmd.Flags().Int64Var(&pageLimit, "limit", 50, "Number of posts")
+	inspectPageCmd.Flags().StringVar(&pageCursor, "cursor", "", "Cursor returned by a previous page")
+	inspectPageCmd.Flags().StringVar(&pageViewer, "viewer", "", "DID of the account to personalize the page for")
+	var graphLimit int
+	inspectGraphCmd := &cobra.Command{
+		Use:   "graph <did>",
+		Short: "List the follows and blocks recorded to and from an account",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWithDB(func(cfg Config) error {
+				return commands.InspectGraph(cfg, args[0], graphLimit)
+			})(cmd, args)
+		},
+	}
+	inspectGraphCmd.Flags().IntVar(&graphLimit, "limit", 100, "Maximum number of accounts of each kind")
+	inspectRecordsCmd := &cobra.Command{
+		Use:   "records",
+		Short: "Print the putRecord requests that publish the feeds' generator records",
+		Args:  cobra.NoArgs,
+		RunE: runWithDB(func(cfg Config) error {
+			if err := cfg.ValidateFeeds(); err != nil {
+				return fmt.Errorf("invalid config: %w", err)
 			}
-			if err := cfg.Validate(); err != nil {
+			return commands.InspectRecords(cfg)
+		}),
+	}
+	inspectCmd.AddCommand(inspectFeedsCmd, inspectPageCmd, inspectGraphCmd, inspectRecordsCmd)
+
+	var realtime bool
+	replayCmd := &cobra.Command{
+		Use:   "replay <file>",
+		Short: "Run a recording made with --consumer.record_file through the feeds",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWithDB(func(cfg Config) error {
+				if err := cfg.ValidateFeeds(); err != nil {
+					return fmt.Errorf("invalid config: %w", err)
+				}
+				return commands.Replay(cfg, args[0], realtime)
+			})(cmd, args)
+		},
+	}
+	replayCmd.Flags().BoolVar(&realtime, "realtime", false, "Replay events at the pace they were recorded")
+
+	deadLettersCmd := &cobra.Command{
+		Use:   "dead-letters",
+		Short: "Manage events the consumer failed to handle",
+	}
+	var deadLetterLimit int
+	deadLettersListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List dead letters, oldest first",
+		Args:  cobra.NoArgs,
+		RunE: runWithDB(func(cfg Config) error {
+			return commands.ListDeadLetters(cfg, deadLetterLimit)
+		}),
+	}
+	deadLettersReprocessCmd := &cobra.Command{
+		Use:   "reprocess",
+		Short: "Run dead letters through the feeds again, deleting the ones that succeed",
+		Args:  cobra.NoArgs,
+		RunE: runWithDB(func(cfg Config) error {
+			if err := cfg.ValidateFeeds(); err != nil {
 				return fmt.Errorf("invalid config: %w", err)
 			}
-			return runFn(cfg)
+			return commands.ReprocessDeadLetters(cfg, deadLetterLimit)
+		}),
+	}
+	for _, c := range []*cobra.Command{deadLettersListCmd, deadLettersReprocessCmd} {
+		c.Flags().IntVar(&deadLetterLimit, "limit", 0, "Maximum number of dead letters (0 for all)")
+	}
+	deadLettersCmd.AddCommand(deadLettersListCmd, deadLettersReprocessCmd)
+
+	graphCmd := &cobra.Command{
+		Use:   "graph",
+		Short: "Manage the recorded follows and blocks",
+	}
+	graphBootstrapCmd := &cobra.Command{
+		Use:   "bootstrap <car>...",
+		Short: "Replace accounts' follows and blocks with those in exports of their repos",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWithDB(func(cfg Config) error {
+				return commands.BootstrapGraph(cfg, args)
+			})(cmd, args)
 		},
 	}
+	graphCmd.AddCommand(graphBootstrapCmd)
+
+	cmd.AddCommand(serveCmd, consumeCmd, migrateCmd, inspectCmd, replayCmd, deadLettersCmd, graphCmd)
 
 	setupFlags(cmd)
 	setupConfig(cmd)
@@ -0,0 +1,72 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/store"
+)
+
+// accountStatusDeleted is the status the relay reports for accounts that have
+// been deleted outright; their posts and follows and blocks are purged rather
+// than hidden.
+const accountStatusDeleted = "deleted"
+
+// accountHandler records account status changes. Posts from inactive
+// accounts (deactivated, taken down, suspended) stay in feed_posts but are
+// filtered out when serving feeds, so they come back if the account is
+// reactivated.
+type accountHandler struct {
+	logger *slog.Logger
+	batch  *BatchWriter
+}
+
+func newAccountHandler(logger *slog.Logger, batch *BatchWriter) *accountHandler {
+	return &accountHandler{
+		logger: logger,
+		batch:  batch,
+	}
+}
+
+func (a *accountHandler) HandleAccount(ctx context.Context, event *models.Event) error {
+	acct := event.Account
+	var status string
+	if acct.Status != nil {
+		status = *acct.Status
+	}
+	a.logger.Debug("account status changed", "did", event.Did, "active", acct.Active, "status", status)
+
+	account := store.Account{
+		Did:    event.Did,
+		Active: acct.Active,
+		Status: status,
+		TimeUs: event.TimeUS,
+	}
+	purge := !acct.Active && status == accountStatusDeleted
+	return a.batch.Add(ctx, func(ctx context.Context, w store.Writer) error {
+		if err := w.UpsertAccount(ctx, account); err != nil {
+			return fmt.Errorf("failed to upsert account: %w", err)
+		}
+		if purge {
+			if err := w.DeleteAccountPosts(ctx, account.Did); err != nil {
+				return fmt.Errorf("failed to delete account posts: %w", err)
+			}
+			if err := w.DeleteActorGraphEdges(ctx, account.Did); err != nil {
+				return fmt.Errorf("failed to delete account graph edges: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (a *accountHandler) HandleIdentity(ctx context.Context, event *models.Event) error {
+	// Feed posts are keyed by DID, so handle changes don't need any action.
+	var handle string
+	if event.Identity.Handle != nil {
+		handle = *event.Identity.Handle
+	}
+	a.logger.Debug("identity changed", "did", event.Did, "handle", handle)
+	return nil
+}
@@ -0,0 +1,309 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/metrics"
+	"jetstream-feed-generator/store"
+)
+
+// writeOp is a database write buffered by a BatchWriter.
+type writeOp func(ctx context.Context, w store.Writer) error
+
+// eventWrites are the writes made while handling one event. They are applied
+// or rejected together, so an event is never left half written.
+type eventWrites struct {
+	// event is nil for writes not made on behalf of an event, such as dead
+	// letters.
+	event *models.Event
+	ops   []writeOp
+}
+
+// stagedWritesKey is the context key for the eventWrites that Add stages
+// writes in while an event is handled.
+type stagedWritesKey struct{}
+
+// stageWrites returns a context under which BatchWriter.Add collects writes
+// in the returned eventWrites instead of buffering them.
+func stageWrites(ctx context.Context, event *models.Event) (context.Context, *eventWrites) {
+	staged := &eventWrites{event: event}
+	return context.WithValue(ctx, stagedWritesKey{}, staged), staged
+}
+
+// Bounds on buffering and flushing.
+const (
+	// maxBufferedBatches caps the buffer at this many times the batch size;
+	// Add blocks beyond that until a flush makes room.
+	maxBufferedBatches = 4
+	// flushAttempts bounds the attempts to apply a whole batch before the
+	// events whose writes fail are isolated.
+	flushAttempts = 3
+)
+
+// errProbe rolls back the transactions that only check whether writes apply.
+var errProbe = errors.New("probe transaction")
+
+// BatchWriter buffers the database writes made while handling events and
+// applies them in a single transaction together with the feed cursors, so
+// stored posts and checkpoints always agree.
+type BatchWriter struct {
+	store       store.Store
+	logger      *slog.Logger
+	batchSize   int
+	maxBuffered int
+	// failures, if set, decides what happens to events whose writes fail
+	// to apply. Without it a failed flush leaves the whole batch buffered.
+	failures *failureHandler
+
+	mu       sync.Mutex
+	pending  []eventWrites
+	buffered int
+	// space is closed, and replaced, whenever a flush frees up the buffer.
+	space chan struct{}
+	full  chan struct{}
+}
+
+func NewBatchWriter(st store.Store, logger *slog.Logger, batchSize int) *BatchWriter {
+	return &BatchWriter{
+		store:       st,
+		logger:      logger,
+		batchSize:   batchSize,
+		maxBuffered: maxBufferedBatches * batchSize,
+		space:       make(chan struct{}),
+		full:        make(chan struct{}, 1),
+	}
+}
+
+// Add buffers a write until the next flush. While an event is being handled
+// the write is staged with the event's other writes, which are buffered
+// once the event has been handled successfully; otherwise Add blocks while
+// the buffer is full, until a flush makes room or ctx is done.
+func (b *BatchWriter) Add(ctx context.Context, op writeOp) error {
+	if staged, ok := ctx.Value(stagedWritesKey{}).(*eventWrites); ok {
+		staged.ops = append(staged.ops, op)
+		return nil
+	}
+	return b.add(ctx, eventWrites{ops: []writeOp{op}})
+}
+
+// add buffers an event's writes, blocking while the buffer is full. A group
+// larger than the whole buffer is let in once the buffer is empty.
+func (b *BatchWriter) add(ctx context.Context, writes eventWrites) error {
+	if len(writes.ops) == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	for b.buffered > 0 && b.buffered+len(writes.ops) > b.maxBuffered {
+		space := b.space
+		b.mu.Unlock()
+		b.signalFull()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-space:
+		}
+		b.mu.Lock()
+	}
+	b.pending = append(b.pending, writes)
+	b.buffered += len(writes.ops)
+	full := b.buffered >= b.batchSize
+	b.mu.Unlock()
+	if full {
+		b.signalFull()
+	}
+	return nil
+}
+
+func (b *BatchWriter) signalFull() {
+	select {
+	case b.full <- struct{}{}:
+	default:
+	}
+}
+
+// Full is signalled when the buffer reaches the batch size.
+func (b *BatchWriter) Full() <-chan struct{} {
+	return b.full
+}
+
+// Flush applies the buffered writes and saves the given feed cursors in one
+// transaction. Callers must compute the cursors before calling Flush, so that
+// every write for events up to the cursors is already buffered.
+//
+// A batch that fails is retried a few times. If it still fails while the
+// database is reachable, the events whose writes fail are found and handed
+// to the failure policy, and the rest are written with the cursors. If that
+// isn't possible, the writes are kept for the next flush.
+func (b *BatchWriter) Flush(ctx context.Context, cursors map[string]int64) error {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	start := time.Now()
+	err := b.applyWithRetries(ctx, batch, cursors)
+	if err != nil && b.failures != nil && ctx.Err() == nil {
+		if pingErr := b.store.Ping(ctx); pingErr != nil {
+			b.logger.Warn("database unreachable, keeping batch for the next flush", "error", pingErr)
+		} else {
+			err = b.isolate(ctx, batch, cursors, err)
+		}
+	}
+	metrics.DBWriteDuration.Observe(time.Since(start).Seconds())
+
+	b.mu.Lock()
+	if err != nil {
+		b.pending = append(batch, b.pending...)
+	} else {
+		for _, writes := range batch {
+			b.buffered -= len(writes.ops)
+		}
+		close(b.space)
+		b.space = make(chan struct{})
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	b.logger.Debug("flushed batch", "events", len(batch))
+	return nil
+}
+
+// applyWithRetries applies the batch, retrying with a growing delay if it
+// fails.
+func (b *BatchWriter) applyWithRetries(ctx context.Context, batch []eventWrites, cursors map[string]int64) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = b.apply(ctx, batch, cursors)
+		if err == nil || attempt == flushAttempts {
+			return err
+		}
+		b.logger.Warn("failed to flush batch, retrying", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(retryDelay << (attempt - 1)):
+		}
+	}
+}
+
+// isolate finds the events whose writes fail, in order, by bisecting the
+// batch with transactions that are rolled back. Each one is handed to the
+// failure policy, which may retry them, and the remaining writes, the dead
+// letters and the cursors are then applied in one transaction.
+func (b *BatchWriter) isolate(ctx context.Context, batch []eventWrites, cursors map[string]int64, flushErr error) error {
+	var good []eventWrites
+	rest := batch
+	for len(rest) > 0 {
+		i, opErr, err := b.firstFailing(ctx, good, rest)
+		if err != nil {
+			return err
+		}
+		if i < 0 && len(rest) == len(batch) {
+			// Every write applies, so it was the cursors or the commit
+			// that failed, which no event can be blamed for.
+			return flushErr
+		}
+		if i < 0 {
+			good = append(good, rest...)
+			break
+		}
+		good = append(good, rest[:i]...)
+		failed := rest[i]
+		rest = rest[i+1:]
+
+		if failed.event == nil {
+			metrics.HandlerErrors.Inc()
+			b.logger.Error("dropping writes that failed to apply", "writes", len(failed.ops), "error", opErr)
+			continue
+		}
+		deadLetter, err := b.failures.writeFailed(ctx, failed.event, opErr, func() error {
+			return b.probe(ctx, good, []eventWrites{failed})
+		})
+		if err != nil {
+			return err
+		}
+		if deadLetter == nil {
+			// The writes applied when retried.
+			good = append(good, failed)
+		} else {
+			good = append(good, eventWrites{ops: []writeOp{deadLetter}})
+		}
+	}
+	return b.applyWithRetries(ctx, good, cursors)
+}
+
+// firstFailing returns the index in rest of the first event whose writes
+// fail when applied after good and the events before it in rest, with the
+// error they fail with, or -1 if none fail. good must apply.
+func (b *BatchWriter) firstFailing(ctx context.Context, good, rest []eventWrites) (int, error, error) {
+	err := b.probe(ctx, good, rest)
+	if err == nil {
+		return -1, nil, nil
+	}
+	if ctx.Err() != nil {
+		return 0, nil, ctx.Err()
+	}
+	// rest[:lo] applies and rest[:hi] fails with hiErr.
+	lo, hi, hiErr := 0, len(rest), err
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if err := b.probe(ctx, good, rest[:mid]); err != nil {
+			hi, hiErr = mid, err
+		} else {
+			lo = mid
+		}
+		if ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+	}
+	return hi - 1, hiErr, nil
+}
+
+// probe applies good followed by rest in a transaction that is rolled back,
+// returning the error the writes fail with, if any.
+func (b *BatchWriter) probe(ctx context.Context, good, rest []eventWrites) error {
+	err := b.store.InTx(ctx, func(w store.Writer) error {
+		for _, writes := range [][]eventWrites{good, rest} {
+			if err := applyWrites(ctx, w, writes); err != nil {
+				return err
+			}
+		}
+		return errProbe
+	})
+	if errors.Is(err, errProbe) {
+		return nil
+	}
+	return err
+}
+
+func (b *BatchWriter) apply(ctx context.Context, batch []eventWrites, cursors map[string]int64) error {
+	return b.store.InTx(ctx, func(w store.Writer) error {
+		if err := applyWrites(ctx, w, batch); err != nil {
+			return err
+		}
+		for feedName, cursor := range cursors {
+			if err := w.UpdateFeedCursor(ctx, feedName, cursor); err != nil {
+				return fmt.Errorf("failed to save cursor for feed %s: %w", feedName, err)
+			}
+		}
+		return nil
+	})
+}
+
+func applyWrites(ctx context.Context, w store.Writer, batch []eventWrites) error {
+	for _, writes := range batch {
+		for _, op := range writes.ops {
+			if err := op(ctx, w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/store"
+	"jetstream-feed-generator/store/sqlite"
+)
+
+const testFeed = "test-feed"
+
+func openStore(t *testing.T) store.Store {
+	t.Helper()
+	st, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	ctx := context.Background()
+	if _, err := st.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertFeed(ctx, testFeed); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// postWrites are the writes of an event that adds a post to the test feed.
+func postWrites(timeUS int64, did string) eventWrites {
+	post := store.FeedPost{FeedName: testFeed, TimeUs: timeUS, Did: did, Rkey: "rkey"}
+	return eventWrites{
+		event: &models.Event{TimeUS: timeUS, Did: did},
+		ops: []writeOp{func(ctx context.Context, w store.Writer) error {
+			return w.UpsertFeedPost(ctx, post)
+		}},
+	}
+}
+
+var errBroken = errors.New("broken write")
+
+// brokenWrites are the writes of an event that always fail to apply, after
+// adding a post that must not be kept.
+func brokenWrites(timeUS int64, did string) eventWrites {
+	writes := postWrites(timeUS, did)
+	writes.ops = append(writes.ops, func(ctx context.Context, w store.Writer) error {
+		return errBroken
+	})
+	return writes
+}
+
+func feedPostDIDs(t *testing.T, st store.Store) []string {
+	t.Helper()
+	posts, err := st.GetFeedPosts(context.Background(), store.GetFeedPostsParams{
+		FeedName: testFeed, Before: 1 << 62, Limit: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dids []string
+	for _, p := range posts {
+		dids = append(dids, p.Did)
+	}
+	return dids
+}
+
+func TestFlushDeadLettersEventsWhoseWritesFail(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	batch := NewBatchWriter(st, slog.Default(), 100)
+	batch.failures = &failureHandler{logger: slog.Default(), policy: FailureSkip, stop: func(error) {}}
+
+	for _, writes := range []eventWrites{
+		postWrites(10, "did:plc:a"),
+		brokenWrites(20, "did:plc:b"),
+		postWrites(30, "did:plc:c"),
+		brokenWrites(40, "did:plc:d"),
+		postWrites(50, "did:plc:e"),
+	} {
+		if err := batch.add(ctx, writes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Flush(ctx, map[string]int64{testFeed: 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := feedPostDIDs(t, st)
+	want := []string{"did:plc:e", "did:plc:c", "did:plc:a"}
+	if len(got) != len(want) {
+		t.Fatalf("feed posts = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("feed posts = %v, want %v", got, want)
+		}
+	}
+	letters, err := st.ListDeadLetters(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 2 || letters[0].Did != "did:plc:b" || letters[1].Did != "did:plc:d" {
+		t.Fatalf("dead letters = %+v, want did:plc:b and did:plc:d", letters)
+	}
+	if letters[0].Error != errBroken.Error() {
+		t.Errorf("dead letter error = %q, want %q", letters[0].Error, errBroken.Error())
+	}
+	cursor, err := st.FeedCursor(ctx, testFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != 50 {
+		t.Errorf("cursor = %d, want 50", cursor)
+	}
+}
+
+func TestFlushStopsWithoutWriting(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	batch := NewBatchWriter(st, slog.Default(), 100)
+	var stopped error
+	batch.failures = &failureHandler{logger: slog.Default(), policy: FailureStop, stop: func(err error) { stopped = err }}
+
+	for _, writes := range []eventWrites{postWrites(10, "did:plc:a"), brokenWrites(20, "did:plc:b")} {
+		if err := batch.add(ctx, writes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := batch.Flush(ctx, map[string]int64{testFeed: 20})
+	if !errors.Is(err, errBroken) {
+		t.Fatalf("Flush error = %v, want %v", err, errBroken)
+	}
+	if !errors.Is(stopped, errBroken) || !errors.Is(stopped, ErrStopped) {
+		t.Errorf("stopped with %v, want %v wrapping %v", stopped, ErrStopped, errBroken)
+	}
+	if got := feedPostDIDs(t, st); len(got) != 0 {
+		t.Errorf("feed posts = %v, want none", got)
+	}
+	if cursor, _ := st.FeedCursor(ctx, testFeed); cursor != 0 {
+		t.Errorf("cursor = %d, want 0", cursor)
+	}
+	if len(batch.pending) != 2 {
+		t.Errorf("%d events buffered, want 2 kept for the next flush", len(batch.pending))
+	}
+}
+
+func TestFlushRetriesFailedWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures is the number of times the write fails before it
+		// applies. The first four are the flush attempts and the probe
+		// that isolates it.
+		failures     int
+		wantDIDs     []string
+		wantAttempts int64
+	}{
+		{"applies when retried", 5, []string{"did:plc:b", "did:plc:a"}, 0},
+		{"fails every retry", 100, []string{"did:plc:a"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := openStore(t)
+			batch := NewBatchWriter(st, slog.Default(), 100)
+			batch.failures = &failureHandler{logger: slog.Default(), policy: FailureRetry, maxRetries: 2, stop: func(error) {}}
+
+			calls := 0
+			flaky := postWrites(20, "did:plc:b")
+			flaky.ops = append(flaky.ops, func(ctx context.Context, w store.Writer) error {
+				if calls++; calls <= tt.failures {
+					return errBroken
+				}
+				return nil
+			})
+			for _, writes := range []eventWrites{postWrites(10, "did:plc:a"), flaky} {
+				if err := batch.add(ctx, writes); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := batch.Flush(ctx, map[string]int64{testFeed: 20}); err != nil {
+				t.Fatal(err)
+			}
+
+			got := feedPostDIDs(t, st)
+			if len(got) != len(tt.wantDIDs) {
+				t.Fatalf("feed posts = %v, want %v", got, tt.wantDIDs)
+			}
+			for i := range got {
+				if got[i] != tt.wantDIDs[i] {
+					t.Fatalf("feed posts = %v, want %v", got, tt.wantDIDs)
+				}
+			}
+			letters, err := st.ListDeadLetters(ctx, 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantAttempts == 0 {
+				if len(letters) != 0 {
+					t.Errorf("dead letters = %+v, want none", letters)
+				}
+				return
+			}
+			if len(letters) != 1 || letters[0].Attempts != tt.wantAttempts {
+				t.Errorf("dead letters = %+v, want one after %d attempts", letters, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestFlushWithoutFailureHandlerKeepsBatch(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	batch := NewBatchWriter(st, slog.Default(), 100)
+
+	for _, writes := range []eventWrites{postWrites(10, "did:plc:a"), brokenWrites(20, "did:plc:b")} {
+		if err := batch.add(ctx, writes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Flush(ctx, nil); !errors.Is(err, errBroken) {
+		t.Fatalf("Flush error = %v, want %v", err, errBroken)
+	}
+	if got := feedPostDIDs(t, st); len(got) != 0 {
+		t.Errorf("feed posts = %v, want none", got)
+	}
+	if len(batch.pending) != 2 {
+		t.Errorf("%d events buffered, want 2", len(batch.pending))
+	}
+}
+
+func TestAddBlocksWhenBufferIsFull(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	batch := NewBatchWriter(st, slog.Default(), 1)
+
+	for i := range maxBufferedBatches {
+		if err := batch.add(ctx, postWrites(int64(i+1), "did:plc:a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := batch.Add(timeoutCtx, postWrites(100, "did:plc:b").ops[0]); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Add to a full buffer = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	added := make(chan error, 1)
+	go func() {
+		added <- batch.Add(ctx, postWrites(100, "did:plc:b").ops[0])
+	}()
+	select {
+	case err := <-added:
+		t.Fatalf("Add returned %v before a flush made room", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := batch.Flush(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Add still blocked after a flush")
+	}
+}
+
+func TestAddStagesWritesWhileHandlingAnEvent(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	batch := NewBatchWriter(st, slog.Default(), 100)
+
+	stagedCtx, staged := stageWrites(ctx, &models.Event{TimeUS: 10, Did: "did:plc:a"})
+	if err := batch.Add(stagedCtx, postWrites(10, "did:plc:a").ops[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(staged.ops) != 1 || len(batch.pending) != 0 {
+		t.Fatalf("%d writes staged and %d events buffered, want 1 and 0", len(staged.ops), len(batch.pending))
+	}
+}
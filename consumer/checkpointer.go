@@ -0,0 +1,80 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"jetstream-feed-generator/metrics"
+)
+
+// shutdownCheckpointTimeout bounds the final checkpoint written on shutdown.
+const shutdownCheckpointTimeout = 10 * time.Second
+
+// checkpointer commits buffered writes together with the feed cursors at a
+// regular interval (or sooner if the batch fills up), and periodically logs
+// consumer stats. It writes one last checkpoint when stopped.
+type checkpointer struct {
+	logger             *slog.Logger
+	batch              *BatchWriter
+	handler            *handler
+	connector          *connector
+	status             *Status
+	checkpointInterval time.Duration
+	statsInterval      time.Duration
+}
+
+// Run checkpoints until ctx is done. Callers should cancel ctx only after the
+// scheduler has drained, so the final checkpoint covers every handled event.
+func (c *checkpointer) Run(ctx context.Context) {
+	checkpointTicker := time.NewTicker(c.checkpointInterval)
+	defer checkpointTicker.Stop()
+	statsTicker := time.NewTicker(c.statsInterval)
+	defer statsTicker.Stop()
+
+	for {
+		select {
+		case <-checkpointTicker.C:
+			c.checkpoint(ctx)
+		case <-c.batch.Full():
+			c.checkpoint(ctx)
+		case <-statsTicker.C:
+			c.logStats()
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownCheckpointTimeout)
+			c.checkpoint(shutdownCtx)
+			cancel()
+			c.logger.Info("final checkpoint", "latest_cursor", c.handler.tracker.Cursor())
+			return
+		}
+	}
+}
+
+func (c *checkpointer) checkpoint(ctx context.Context) {
+	latestCursor := c.handler.tracker.Cursor()
+	feedCursors := c.handler.feedCursors(latestCursor)
+	if err := c.batch.Flush(ctx, feedCursors); err != nil {
+		c.logger.Error("failed to checkpoint", "error", err)
+		return
+	}
+	c.status.setCursors(latestCursor, feedCursors)
+}
+
+func (c *checkpointer) logStats() {
+	eventsRead := c.connector.EventsRead()
+	bytesRead := c.connector.BytesRead()
+	var avgEventSize int64
+	if eventsRead > 0 {
+		avgEventSize = bytesRead / eventsRead
+	}
+	// Progress is reported whether or not checkpoints succeed, so a stalled
+	// consumer shows up as lag.
+	latestCursor := c.handler.tracker.Cursor()
+	lag := time.Since(time.UnixMicro(latestCursor)).Seconds()
+	metrics.ConsumerCursor.Set(float64(latestCursor))
+	metrics.ConsumerLag.Set(lag)
+	c.logger.Info(
+		"stats", "events_read", eventsRead, "bytes_read", bytesRead,
+		"avg_event_size", avgEventSize, "latest_cursor", latestCursor, "lag_s", lag,
+	)
+}
@@ -2,81 +2,49 @@ package consumer
 
 import (
 	"context"
-	"database/sql"
-	"fmt"
 	apibsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/jetstream/pkg/models"
-	dbpkg "jetstream-feed-generator/db/sqlc"
+	"jetstream-feed-generator/store"
 	"log/slog"
 	"regexp"
 	"strings"
 )
 
 type ComposerErrorsFeed struct {
-	name   string
-	logger *slog.Logger
-	q      *dbpkg.Queries
+	feedStore
 }
 
-func NewComposerErrorsFeed(name string, logger *slog.Logger, db *sql.DB) *ComposerErrorsFeed {
-	feedLogger := logger.With("feed", name)
+func NewComposerErrorsFeed(name string, logger *slog.Logger, st store.Store, batch *BatchWriter) *ComposerErrorsFeed {
 	return &ComposerErrorsFeed{
-		name:   name,
-		logger: feedLogger,
-		q:      dbpkg.New(db),
+		feedStore: newFeedStore(name, logger, st, batch),
 	}
 }
 
-func (f *ComposerErrorsFeed) Name() string {
-	return f.name
-}
-
-func (f *ComposerErrorsFeed) Initialize(ctx context.Context) error {
-	if err := f.q.UpsertFeed(ctx, f.Name()); err != nil {
-		return fmt.Errorf("failed to upsert feed: %v", err)
+func (f *ComposerErrorsFeed) HandlePost(ctx context.Context, event *models.Event, post *apibsky.FeedPost) error {
+	if isComposerError(post) {
+		f.logMatch(event, post)
+		return f.addPost(ctx, event)
 	}
 	return nil
 }
 
-func (f *ComposerErrorsFeed) LatestCursor(ctx context.Context) (int64, error) {
-	feed, err := f.q.GetFeed(ctx, f.Name())
-	if err != nil {
-		return 0, err
-	}
-	if feed.LatestCursor.Valid {
-		return feed.LatestCursor.Int64, nil
-	}
-	return 0, nil
-}
-
-func (f *ComposerErrorsFeed) SaveCursor(ctx context.Context, cursor int64) error {
-	err := f.q.UpdateFeedCursor(ctx, dbpkg.UpdateFeedCursorParams{
-		LatestCursor: sql.NullInt64{Int64: cursor, Valid: true},
-		FeedName:     f.Name(),
-	})
-	if err != nil {
-		return err
+// HandleUpdate keeps the feed in line with the edited record: a post that
+// still matches is moved to the time of the edit, and one that no longer
+// matches is dropped.
+func (f *ComposerErrorsFeed) HandleUpdate(ctx context.Context, event *models.Event, post *apibsky.FeedPost) error {
+	if isComposerError(post) {
+		f.logMatch(event, post)
+		return f.addPost(ctx, event)
 	}
-	return nil
+	return f.HandleDelete(ctx, event)
 }
 
-func (f *ComposerErrorsFeed) HandlePost(ctx context.Context, event *models.Event, post *apibsky.FeedPost) error {
-	if isComposerError(post) {
-		f.logger.Debug(
-			"post matched", "did", event.Did, "rkey", event.Commit.RKey,
-			"text", post.Text, "uri", post.Embed.EmbedExternal.External.Uri,
-		)
-		err := f.q.UpsertFeedPost(ctx, dbpkg.UpsertFeedPostParams{
-			FeedName: f.Name(),
-			TimeUs:   event.TimeUS,
-			Did:      event.Did,
-			Rkey:     event.Commit.RKey,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to upsert feed post: %w", err)
-		}
-	}
-	return nil
+func (f *ComposerErrorsFeed) logMatch(event *models.Event, post *apibsky.FeedPost) {
+	f.logger.Debug(
+		"post matched", "did", event.Did, "rkey", event.Commit.RKey,
+		"operation", event.Commit.Operation,
+		"text", post.Text, "uri", post.Embed.EmbedExternal.External.Uri,
+	)
 }
 
 var domainRe = regexp.MustCompile("^https://(([A-Za-z0-9-]+)\\.([A-Za-z0-9]+))$")
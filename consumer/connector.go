@@ -0,0 +1,356 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	jetstreamClient "github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/gorilla/websocket"
+	"jetstream-feed-generator/metrics"
+)
+
+// Bounds for the time an endpoint is left alone after a failed connection,
+// before jitter.
+const (
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = 2 * time.Minute
+)
+
+// Health probes subscribe to an instance's live stream and check that an
+// event arrives within probeTimeout, stamped no more than probeMaxLag ago.
+const (
+	probeTimeout = 10 * time.Second
+	probeMaxLag  = time.Minute
+)
+
+// endpoint is a Jetstream instance and its recent connection failures.
+type endpoint struct {
+	url string
+	// failures counts consecutive connections that failed without
+	// receiving any events.
+	failures int
+	retryAt  time.Time
+}
+
+// connector keeps a connection open to one of a list of Jetstream
+// instances. It connects to the first instance in the list that isn't
+// backing off after failed connections and, if there is a choice, that
+// passes a health probe. A connection that stops delivering events is
+// treated as failed, and while connected to a less preferred instance the
+// connector probes the ones before it and switches back once one is
+// healthy. Each new connection resumes from the last event received.
+// Instances stamp events with their own clocks, so when switching instances
+// the cursor is rewound by a safety margin; the events replayed as a result
+// are handled again, which feeds tolerate.
+type connector struct {
+	logger       *slog.Logger
+	endpoints    []*endpoint
+	clientConfig jetstreamClient.ClientConfig
+	scheduler    jetstreamClient.Scheduler
+	tracker      *cursorTracker
+	status       *Status
+	rewind       time.Duration
+	// giveUpAfter is how long the connector keeps retrying without
+	// receiving any events before Run fails. Zero retries forever.
+	giveUpAfter time.Duration
+	// stallTimeout is how long a connection may go without events before
+	// it is dropped. Zero waits forever.
+	stallTimeout time.Duration
+	// probeInterval is how often more preferred instances are probed while
+	// connected to another one. Zero never switches back.
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+
+	mu     sync.Mutex
+	stream *stream
+	// eventsRead and bytesRead total the streams of past connections.
+	eventsRead int64
+	bytesRead  int64
+}
+
+func newConnector(logger *slog.Logger, config Config, clientConfig jetstreamClient.ClientConfig,
+	scheduler jetstreamClient.Scheduler, tracker *cursorTracker, status *Status,
+) *connector {
+	c := &connector{
+		logger:        logger,
+		clientConfig:  clientConfig,
+		scheduler:     scheduler,
+		tracker:       tracker,
+		status:        status,
+		rewind:        config.FailoverRewind,
+		giveUpAfter:   config.GiveUpAfter,
+		stallTimeout:  config.StallTimeout,
+		probeInterval: config.ProbeInterval,
+		probeTimeout:  probeTimeout,
+	}
+	for _, url := range config.JetstreamURLs {
+		c.endpoints = append(c.endpoints, &endpoint{url: url})
+	}
+	return c
+}
+
+// switchEndpoint ends a healthy connection to move to a more preferred
+// endpoint.
+type switchEndpoint struct {
+	to *endpoint
+}
+
+func (e *switchEndpoint) Error() string {
+	return "switching to " + e.to.url
+}
+
+// Run connects and reads events until ctx is done, or returns an error once
+// it has gone giveUpAfter without receiving events.
+func (c *connector) Run(ctx context.Context) error {
+	var previous, switchTo *endpoint
+	lastReceived := time.Now()
+	for {
+		ep := switchTo
+		switchTo = nil
+		if ep == nil {
+			var wait time.Duration
+			ep, wait = c.next(ctx, time.Now())
+			if wait > 0 {
+				c.logger.Warn("all Jetstream endpoints are failing, waiting to retry", "retry_in", wait)
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(wait):
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		cursor := c.tracker.Received()
+		if previous != nil && ep != previous {
+			cursor -= c.rewind.Microseconds()
+			c.logger.Info("failing over to another Jetstream endpoint", "from", previous.url, "to", ep.url,
+				"cursor", cursor, "rewind", c.rewind)
+		}
+		previous = ep
+
+		eventsRead, err := c.connect(ctx, ep, cursor)
+		c.status.setConnected(false)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if eventsRead > 0 {
+			ep.failures = 0
+			lastReceived = time.Now()
+		}
+		var sw *switchEndpoint
+		if errors.As(err, &sw) {
+			switchTo = sw.to
+			continue
+		}
+		if err == nil {
+			err = fmt.Errorf("connection closed")
+		}
+		metrics.ConsumerDisconnects.WithLabelValues(ep.url).Inc()
+
+		ep.failures++
+		backoff := jitter(min(reconnectMinBackoff<<min(ep.failures-1, 16), reconnectMaxBackoff))
+		ep.retryAt = time.Now().Add(backoff)
+		c.logger.Warn("disconnected from Jetstream", "url", ep.url, "error", err,
+			"events_read", eventsRead, "failures", ep.failures, "backoff", backoff)
+
+		if c.giveUpAfter > 0 && time.Since(lastReceived) >= c.giveUpAfter {
+			return fmt.Errorf("no events received from Jetstream for %s, giving up: %w",
+				time.Since(lastReceived).Round(time.Second), err)
+		}
+	}
+}
+
+// jitter spreads reconnects out over the second half of the backoff, so
+// consumers that lost the same instance don't all return at once.
+func jitter(backoff time.Duration) time.Duration {
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// next returns the first endpoint that isn't backing off and passes a
+// health probe, or the first that isn't backing off if none pass or there is
+// only one. If they are all backing off, it returns the one that can be
+// retried soonest and how long to wait for it.
+func (c *connector) next(ctx context.Context, now time.Time) (*endpoint, time.Duration) {
+	var candidates []*endpoint
+	soonest := c.endpoints[0]
+	for _, ep := range c.endpoints {
+		if !ep.retryAt.After(now) {
+			candidates = append(candidates, ep)
+		} else if ep.retryAt.Before(soonest.retryAt) {
+			soonest = ep
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return soonest, soonest.retryAt.Sub(now)
+	case 1:
+		return candidates[0], 0
+	}
+	for _, ep := range candidates {
+		if c.healthy(ctx, ep) {
+			return ep, 0
+		}
+	}
+	return candidates[0], 0
+}
+
+// healthy probes an endpoint and logs the outcome.
+func (c *connector) healthy(ctx context.Context, ep *endpoint) bool {
+	lag, err := c.probe(ctx, ep.url)
+	if err == nil && lag > probeMaxLag {
+		err = fmt.Errorf("live events are %s behind", lag.Round(time.Second))
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			c.logger.Info("Jetstream endpoint failed health probe", "url", ep.url, "error", err)
+		}
+		return false
+	}
+	return true
+}
+
+// probe subscribes to the live stream at url and returns how long ago the
+// first event it receives was stamped.
+func (c *connector) probe(ctx context.Context, url string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.probeTimeout)
+	defer cancel()
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return 0, fmt.Errorf("no event received: %w", err)
+	}
+	var event struct {
+		TimeUS int64 `json:"time_us"`
+	}
+	if err := json.Unmarshal(msg, &event); err != nil {
+		return 0, fmt.Errorf("failed to decode event: %w", err)
+	}
+	return time.Since(time.UnixMicro(event.TimeUS)), nil
+}
+
+func (c *connector) connect(ctx context.Context, ep *endpoint, cursor int64) (int64, error) {
+	config := c.clientConfig
+	config.WebsocketURL = ep.url
+	s := &stream{config: config, scheduler: c.scheduler}
+	c.mu.Lock()
+	c.stream = s
+	c.mu.Unlock()
+
+	// Endpoints that were backing off when the connection started are left
+	// to their backoff rather than probed.
+	var preferred []*endpoint
+	now := time.Now()
+	for _, other := range c.endpoints {
+		if other == ep {
+			break
+		}
+		if !other.retryAt.After(now) {
+			preferred = append(preferred, other)
+		}
+	}
+	connCtx, cancel := context.WithCancelCause(ctx)
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		c.watch(connCtx, cancel, ep, preferred, s)
+	}()
+
+	// The stream closes its connection when connCtx is done, so a stalled
+	// or abandoned connection is gone before the next one is made.
+	err := s.run(connCtx, cursor)
+	if connCtx.Err() != nil {
+		err = context.Cause(connCtx)
+	}
+	cancel(nil)
+	<-watching
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	eventsRead := s.eventsRead.Load()
+	c.eventsRead += eventsRead
+	c.bytesRead += s.bytesRead.Load()
+	c.stream = nil
+	return eventsRead, err
+}
+
+// watch ends the connection to ep with an error if it goes stallTimeout
+// without events, or with a switchEndpoint if one of the preferred
+// endpoints passes a health probe.
+func (c *connector) watch(ctx context.Context, cancel context.CancelCauseFunc, ep *endpoint,
+	preferred []*endpoint, s *stream,
+) {
+	interval := time.Second
+	if c.stallTimeout > 0 {
+		interval = min(interval, c.stallTimeout/4)
+	}
+	if c.probeInterval > 0 {
+		interval = min(interval, c.probeInterval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var eventsRead int64
+	lastEvent, lastProbe := time.Now(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := s.eventsRead.Load(); n != eventsRead {
+				eventsRead, lastEvent = n, now
+			} else if c.stallTimeout > 0 && now.Sub(lastEvent) >= c.stallTimeout {
+				cancel(fmt.Errorf("no events received for %s", now.Sub(lastEvent).Round(time.Millisecond)))
+				return
+			}
+			if len(preferred) > 0 && c.probeInterval > 0 && now.Sub(lastProbe) >= c.probeInterval {
+				for _, other := range preferred {
+					if c.healthy(ctx, other) {
+						c.logger.Info("switching back to a preferred Jetstream endpoint", "from", ep.url, "to", other.url)
+						cancel(&switchEndpoint{to: other})
+						return
+					}
+				}
+				lastProbe = time.Now()
+			}
+		}
+	}
+}
+
+// EventsRead returns the number of events read over every connection.
+func (c *connector) EventsRead() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stream != nil {
+		return c.eventsRead + c.stream.eventsRead.Load()
+	}
+	return c.eventsRead
+}
+
+// BytesRead returns the number of bytes read over every connection.
+func (c *connector) BytesRead() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stream != nil {
+		return c.bytesRead + c.stream.bytesRead.Load()
+	}
+	return c.bytesRead
+}
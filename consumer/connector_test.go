@@ -0,0 +1,183 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jetstreamClient "github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/jetstreamtest"
+)
+
+// liveServer is a Jetstream server that streams an identity event every few
+// milliseconds while it is live, stamped age ago.
+type liveServer struct {
+	*jetstreamtest.Server
+	live atomic.Bool
+}
+
+func newLiveServer(t *testing.T, age time.Duration) *liveServer {
+	t.Helper()
+	srv := &liveServer{Server: jetstreamtest.NewServer()}
+	srv.live.Store(true)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if srv.live.Load() {
+					srv.AddEvents(&models.Event{
+						Did:    "did:plc:live",
+						TimeUS: now.Add(-age).UnixMicro(),
+						Kind:   models.EventKindIdentity,
+					})
+				}
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(done)
+		<-stopped
+		srv.Close()
+	})
+	return srv
+}
+
+// subscribed reports whether the connector subscribed to srv, as opposed
+// to only probing it.
+func subscribed(srv *liveServer) bool {
+	for _, sub := range srv.Subscriptions() {
+		if len(sub.WantedCollections) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func testConnector(urls []string, stallTimeout, probeInterval time.Duration) *connector {
+	tracker := newCursorTracker(0)
+	status := NewStatus()
+	clientConfig := jetstreamClient.DefaultClientConfig()
+	clientConfig.WantedCollections = []string{"app.bsky.feed.post"}
+	config := Config{JetstreamURLs: urls, StallTimeout: stallTimeout, ProbeInterval: probeInterval}
+	scheduler := &trackingScheduler{Scheduler: discardScheduler{}, ctx: context.Background(), tracker: tracker, status: status}
+	c := newConnector(slog.Default(), config, *clientConfig, scheduler, tracker, status)
+	c.probeTimeout = 200 * time.Millisecond
+	return c
+}
+
+type discardScheduler struct{}
+
+func (discardScheduler) AddWork(context.Context, string, *models.Event) error { return nil }
+func (discardScheduler) Shutdown()                                            {}
+
+// runConnector runs c until the test ends.
+func runConnector(t *testing.T, c *connector) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run = %v", err)
+		}
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	live := newLiveServer(t, 0)
+	stale := newLiveServer(t, time.Hour)
+	silent := newLiveServer(t, 0)
+	silent.live.Store(false)
+	closed := jetstreamtest.NewServer()
+	closed.Close()
+
+	c := testConnector(nil, 0, 0)
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{"live", live.URL, true},
+		{"lagging", stale.URL, false},
+		{"silent", silent.URL, false},
+		{"down", closed.URL, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.healthy(context.Background(), &endpoint{url: tt.url}); got != tt.want {
+				t.Errorf("healthy = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextPrefersHealthyEndpoint(t *testing.T) {
+	silent := newLiveServer(t, 0)
+	silent.live.Store(false)
+	live := newLiveServer(t, 0)
+
+	c := testConnector([]string{silent.URL, live.URL}, 0, 0)
+	ep, wait := c.next(context.Background(), time.Now())
+	if ep.url != live.URL || wait != 0 {
+		t.Errorf("next = %s after %s, want %s now", ep.url, wait, live.URL)
+	}
+
+	// Without a healthy endpoint, the first is used.
+	live.live.Store(false)
+	ep, _ = c.next(context.Background(), time.Now())
+	if ep.url != silent.URL {
+		t.Errorf("next = %s with no healthy endpoint, want %s", ep.url, silent.URL)
+	}
+}
+
+func TestConnectorFailsOverFromStalledEndpoint(t *testing.T) {
+	primary := newLiveServer(t, 0)
+	fallback := newLiveServer(t, 0)
+	c := testConnector([]string{primary.URL, fallback.URL}, 200*time.Millisecond, 0)
+	runConnector(t, c)
+
+	waitFor(t, "subscription to the primary", func() bool { return subscribed(primary) })
+	primary.live.Store(false)
+	waitFor(t, "failover to the fallback", func() bool { return subscribed(fallback) && fallback.Connected() == 1 })
+	waitFor(t, "disconnection from the stalled primary", func() bool { return primary.Connected() == 0 })
+}
+
+func TestConnectorSwitchesBackToPreferredEndpoint(t *testing.T) {
+	primary := newLiveServer(t, 0)
+	primary.live.Store(false)
+	fallback := newLiveServer(t, 0)
+	c := testConnector([]string{primary.URL, fallback.URL}, 0, 50*time.Millisecond)
+	runConnector(t, c)
+
+	waitFor(t, "subscription to the fallback", func() bool { return subscribed(fallback) })
+	if subscribed(primary) {
+		t.Fatal("subscribed to the silent primary")
+	}
+	primary.live.Store(true)
+	waitFor(t, "switch back to the primary", func() bool { return subscribed(primary) && primary.Connected() == 1 })
+	waitFor(t, "disconnection from the fallback", func() bool { return fallback.Connected() == 0 })
+}
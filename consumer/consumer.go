@@ -2,7 +2,6 @@ package consumer
 
 import (
 	"context"
-	"database/sql"
 	_ "embed"
 	"encoding/json"
 	"fmt"
@@ -11,141 +10,415 @@ import (
 
 	apibsky "github.com/bluesky-social/indigo/api/bsky"
 	jetstreamClient "github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/client/schedulers/parallel"
 	"github.com/bluesky-social/jetstream/pkg/client/schedulers/sequential"
 	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/metrics"
+	"jetstream-feed-generator/store"
 )
 
 const DefaultJetstreamURL = "wss://jetstream1.us-east.bsky.network/subscribe"
 
 type Config struct {
-	JetstreamURL string
-	StartCursor  int64
-	DB           *sql.DB
+	// JetstreamURLs lists the Jetstream instances to connect to, in order of
+	// preference.
+	JetstreamURLs []string
+	// FailoverRewind is how far the cursor is rewound when switching to
+	// another Jetstream instance, to cover differences between their clocks.
+	FailoverRewind time.Duration
+	// GiveUpAfter is how long the consumer keeps reconnecting
+	// without receiving any events before RunConsumer returns an error.
+	// Zero retries forever.
+	GiveUpAfter time.Duration
+	// StallTimeout is how long a connection may go without events before
+	// the consumer reconnects, failing over if it can. Zero waits forever.
+	StallTimeout time.Duration
+	// ProbeInterval is how often the instances preferred to the one
+	// connected to are probed, to switch back once one is healthy. Zero
+	// only switches when the connection fails.
+	ProbeInterval time.Duration
+	StartCursor   int64
+	// BackfillLookback is how far back a feed without a saved cursor starts
+	// reading. Zero starts new feeds at the current time.
+	BackfillLookback time.Duration
+	// Workers is the number of events handled concurrently. Events from the
+	// same account are always handled in order. Values below 2 handle all
+	// events sequentially.
+	Workers int
+	// BatchSize and FlushInterval bound how many writes are buffered, and
+	// for how long, before they are committed along with the feed cursors.
+	// Each flush is a checkpoint.
+	BatchSize     int
+	FlushInterval time.Duration
+	StatsInterval time.Duration
+	// PruneInterval is how often feeds' retention policies are enforced.
+	// Zero disables pruning. PruneBatchSize bounds the rows deleted per
+	// transaction.
+	PruneInterval  time.Duration
+	PruneBatchSize int
+	// FailurePolicy is one of FailureSkip, FailureRetry or FailureStop.
+	// MaxRetries bounds the retries under FailureRetry.
+	FailurePolicy string
+	MaxRetries    int
+	// Engagement subscribes to likes and reposts, counting them for posts
+	// in feeds so feeds can be ranked by engagement.
+	Engagement bool
+	// Graph subscribes to follows and blocks, recording them so feeds can
+	// be personalized for the viewer.
+	Graph bool
+	// RecordFile, if set, is a file that every received event is appended
+	// to, for replaying later; see Replay.
+	RecordFile string
+	Feeds      []FeedConfig
+	Store      store.Store
+	// Status, if set, is kept up to date for health checks.
+	Status *Status
 }
 
 type Feed interface {
 	Name() string
 	Initialize(ctx context.Context) error
 	LatestCursor(ctx context.Context) (int64, error)
-	SaveCursor(ctx context.Context, cursor int64) error
 	HandlePost(ctx context.Context, event *models.Event, post *apibsky.FeedPost) error
 }
 
+// PostUpdater is implemented by feeds that re-evaluate a post when its author
+// edits it, so a post can enter, stay in or leave the feed. Feeds that don't
+// implement it see updates through HandlePost.
+type PostUpdater interface {
+	HandleUpdate(ctx context.Context, event *models.Event, post *apibsky.FeedPost) error
+}
+
+// PostDeleter is implemented by feeds that store posts and need to drop them
+// when the author deletes the underlying record.
+type PostDeleter interface {
+	HandleDelete(ctx context.Context, event *models.Event) error
+}
+
 func RunConsumer(ctx context.Context, config Config) error {
 	logger := slog.With("component", "consumer")
-	handler := handler{
-		feeds: []Feed{
-			NewComposerErrorsFeed("composer-errors", logger, config.DB),
-			//NewEnglishTextFeed("english-text", logger),
-		},
-		latestCursor: config.StartCursor,
+	status := config.Status
+	if status == nil {
+		status = NewStatus()
 	}
-
-	for _, f := range handler.feeds {
-		if err := f.Initialize(ctx); err != nil {
-			return fmt.Errorf("failed to initialize feed %s: %v", f.Name(), err)
-		}
+	batch := NewBatchWriter(config.Store, logger, config.BatchSize)
+	handler, err := newHandler(ctx, config.Feeds, logger, config.Store, batch)
+	if err != nil {
+		return err
+	}
+	parentCtx := ctx
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	handler.failures = &failureHandler{
+		logger:     logger,
+		policy:     config.FailurePolicy,
+		maxRetries: config.MaxRetries,
+		stop:       stop,
 	}
+	batch.failures = handler.failures
 
+	startCursor := config.StartCursor
 	var lag float64
-	if handler.latestCursor != 0 {
-		lag = time.Since(time.UnixMicro(handler.latestCursor)).Seconds()
-		logger.Info("starting at requested cursor", "cursor", handler.latestCursor, "lag_s", lag)
+	if startCursor != 0 {
+		// Every feed reprocesses events from the requested cursor.
+		lag = time.Since(time.UnixMicro(startCursor)).Seconds()
+		logger.Info("starting at requested cursor", "cursor", startCursor, "lag_s", lag)
 	} else {
-		var resumeCursor int64
+		// Each feed resumes from its own cursor; the stream starts at the
+		// earliest of them and feeds that are further ahead skip events they
+		// have already seen.
+		now := time.Now()
 		for _, f := range handler.feeds {
 			feedCursor, err := f.LatestCursor(ctx)
 			if err != nil {
 				return fmt.Errorf("failed to get latest cursor for feed %s: %v", f.Name(), err)
 			}
-			if feedCursor != 0 && (resumeCursor == 0 || feedCursor < resumeCursor) {
-				resumeCursor = feedCursor
+			if feedCursor == 0 {
+				feedCursor = now.Add(-config.BackfillLookback).UnixMicro()
+				logger.Info("no saved cursor for feed, starting from lookback window", "feed", f.Name(),
+					"cursor", feedCursor, "lookback", config.BackfillLookback)
+			} else {
+				lag = time.Since(time.UnixMicro(feedCursor)).Seconds()
+				logger.Info("resuming feed from saved cursor", "feed", f.Name(), "saved_cursor", feedCursor, "lag_s", lag)
+			}
+			f.skipUntil = feedCursor
+			if startCursor == 0 || feedCursor < startCursor {
+				startCursor = feedCursor
 			}
-		}
-
-		if resumeCursor == 0 {
-			handler.latestCursor = time.Now().UnixMicro()
-			logger.Info("no saved cursor in database, starting at current time", "cursor", handler.latestCursor)
-		} else {
-			lag = time.Since(time.UnixMicro(resumeCursor)).Seconds()
-			logger.Info("resuming from saved cursor", "saved_cursor", resumeCursor, "lag_s", lag)
-			handler.latestCursor = resumeCursor
 		}
 	}
-	lag = time.Since(time.UnixMicro(handler.latestCursor)).Seconds()
-	logger.Info("starting consumer", "cursor", handler.latestCursor, "lag_s", lag)
+	handler.tracker = newCursorTracker(startCursor)
+	status.setCursors(startCursor, handler.feedCursors(startCursor))
+	lag = time.Since(time.UnixMicro(startCursor)).Seconds()
+	logger.Info("starting consumer", "cursor", startCursor, "lag_s", lag, "workers", max(config.Workers, 1))
 
 	jetstreamConfig := jetstreamClient.DefaultClientConfig()
-	jetstreamConfig.WebsocketURL = config.JetstreamURL
 	jetstreamConfig.Compress = true
 	jetstreamConfig.WantedCollections = append(jetstreamConfig.WantedCollections, "app.bsky.feed.post")
+	if config.Engagement {
+		jetstreamConfig.WantedCollections = append(jetstreamConfig.WantedCollections,
+			store.LikeCollection, store.RepostCollection)
+	}
+	if config.Graph {
+		jetstreamConfig.WantedCollections = append(jetstreamConfig.WantedCollections,
+			store.FollowCollection, store.BlockCollection)
+	}
 
-	scheduler := sequential.NewScheduler("jetstream-feed-generator", logger, handler.HandleEvent)
+	var scheduler jetstreamClient.Scheduler
+	if config.Workers > 1 {
+		scheduler = parallel.NewScheduler(config.Workers, "jetstream-feed-generator", logger, handler.HandleEvent)
+	} else {
+		scheduler = sequential.NewScheduler("jetstream-feed-generator", logger, handler.HandleEvent)
+	}
+	scheduler = &trackingScheduler{Scheduler: scheduler, ctx: ctx, tracker: handler.tracker, status: status}
+	if config.RecordFile != "" {
+		recorder, err := NewRecorder(config.RecordFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				logger.Error("failed to close recording", "error", err)
+			}
+		}()
+		scheduler = &recordingScheduler{Scheduler: scheduler, recorder: recorder}
+		logger.Info("recording events", "file", config.RecordFile)
+	}
 
-	c, err := jetstreamClient.NewClient(jetstreamConfig, logger, scheduler)
-	if err != nil {
-		return fmt.Errorf("failed to create Jetstream client: %v", err)
+	if len(config.JetstreamURLs) == 0 {
+		return fmt.Errorf("no Jetstream URLs configured")
 	}
+	conn := newConnector(logger, config, *jetstreamConfig, scheduler, handler.tracker, status)
 
-	// Every 5 seconds print stats and update the high-water mark in the DB
+	// Checkpoint until the scheduler has drained, then once more
+	cp := checkpointer{
+		logger:             logger,
+		batch:              batch,
+		handler:            handler,
+		connector:          conn,
+		status:             status,
+		checkpointInterval: config.FlushInterval,
+		statsInterval:      config.StatsInterval,
+	}
+	checkpointCtx, stopCheckpointer := context.WithCancel(context.Background())
+	checkpointerDone := make(chan struct{})
 	go func() {
-		ticker := time.NewTicker(1 * time.Second)
-		for {
-			select {
-			case t := <-ticker.C:
-				for _, f := range handler.feeds {
-					err := f.SaveCursor(ctx, handler.latestCursor)
-					if err != nil {
-						logger.Error("failed to save cursor", "feed", f.Name(), "error", err)
-					}
-				}
-				if t.Second()%5 == 0 {
-					eventsRead := c.EventsRead.Load()
-					bytesRead := c.BytesRead.Load()
-					avgEventSize := bytesRead / eventsRead
-					lag := time.Now().Sub(time.UnixMicro(handler.latestCursor)).Seconds()
-					logger.Info(
-						"stats", "events_read", eventsRead, "bytes_read", bytesRead,
-						"avg_event_size", avgEventSize, "latest_cursor", handler.latestCursor, "lag_s", lag,
-					)
-				}
-			case <-ctx.Done():
-				logger.Info("shutdown", "latest_cursor", handler.latestCursor)
-				return
-			}
-		}
+		defer close(checkpointerDone)
+		cp.Run(checkpointCtx)
 	}()
 
-	if err := c.ConnectAndRead(ctx, &handler.latestCursor); err != nil {
-		return fmt.Errorf("failed to connect: %v", err)
+	pruneCtx, stopPruner := context.WithCancel(ctx)
+	prunerDone := make(chan struct{})
+	if config.PruneInterval > 0 {
+		p := pruner{
+			logger:    logger,
+			store:     config.Store,
+			feeds:     config.Feeds,
+			interval:  config.PruneInterval,
+			batchSize: config.PruneBatchSize,
+		}
+		go func() {
+			defer close(prunerDone)
+			p.Run(pruneCtx)
+		}()
+	} else {
+		close(prunerDone)
+	}
+
+	err = conn.Run(ctx)
+	if cause := context.Cause(ctx); cause != nil && cause != parentCtx.Err() {
+		// Stopped by the failure policy rather than shut down.
+		err = cause
+	}
+	stopPruner()
+	scheduler.Shutdown()
+	stopCheckpointer()
+	<-checkpointerDone
+	<-prunerDone
+	if err != nil {
+		return err
 	}
 
 	logger.Info("shutdown")
 	return nil
 }
 
+// newHandler creates and initializes the configured feeds.
+func newHandler(ctx context.Context, feeds []FeedConfig, logger *slog.Logger, st store.Store, batch *BatchWriter) (*handler, error) {
+	h := &handler{
+		batch:      batch,
+		accounts:   newAccountHandler(logger, batch),
+		engagement: newEngagementHandler(logger, batch),
+		graph:      newGraphHandler(logger, batch),
+	}
+	for _, fc := range feeds {
+		f, err := NewFeed(fc, logger, st, batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create feed %s: %v", fc.Name, err)
+		}
+		h.feeds = append(h.feeds, &feedState{Feed: f})
+	}
+	if len(h.feeds) == 0 {
+		return nil, fmt.Errorf("no feeds configured")
+	}
+
+	for _, f := range h.feeds {
+		if err := f.Initialize(ctx); err != nil {
+			return nil, fmt.Errorf("failed to initialize feed %s: %v", f.Name(), err)
+		}
+	}
+	return h, nil
+}
+
+// feedState tracks a feed's position in the event stream shared by all feeds.
+type feedState struct {
+	Feed
+	// skipUntil is the cursor the feed had already processed when the
+	// consumer started; events up to it are not delivered again.
+	skipUntil int64
+}
+
+func (f *feedState) wants(event *models.Event) bool {
+	return event.TimeUS > f.skipUntil
+}
+
+// cursor returns the cursor to save for the feed, given the latest event
+// processed from the shared stream.
+func (f *feedState) cursor(latestCursor int64) int64 {
+	return max(f.skipUntil, latestCursor)
+}
+
 type handler struct {
-	feeds        []Feed
-	latestCursor int64
+	batch      *BatchWriter
+	feeds      []*feedState
+	accounts   *accountHandler
+	engagement *engagementHandler
+	graph      *graphHandler
+	tracker    *cursorTracker
+	failures   *failureHandler
 }
 
+// feedCursors returns the cursor to save for each feed, given the latest
+// completed event.
+func (h *handler) feedCursors(latestCursor int64) map[string]int64 {
+	cursors := make(map[string]int64, len(h.feeds))
+	for _, f := range h.feeds {
+		cursors[f.Name()] = f.cursor(latestCursor)
+	}
+	return cursors
+}
+
+// HandleEvent handles an event, buffers its writes and marks it as completed
+// for checkpointing. Without a failure handler, a failed event is never
+// completed, so no checkpoint moves past it, and the error is returned to
+// the caller.
 func (h *handler) HandleEvent(ctx context.Context, event *models.Event) error {
-	if event.Commit != nil && (event.Commit.Operation == models.CommitOperationCreate || event.Commit.Operation == models.CommitOperationUpdate) {
+	writes, err := h.stageEvent(ctx, event)
+	if err != nil && h.failures != nil {
+		return h.failures.handle(ctx, h, event, err)
+	}
+	if err != nil {
+		metrics.HandlerErrors.Inc()
+		return err
+	}
+	return h.complete(ctx, writes)
+}
+
+// stageEvent handles an event and returns the writes it made. The writes of
+// an event that fails are discarded, so handling it again doesn't repeat
+// them.
+func (h *handler) stageEvent(ctx context.Context, event *models.Event) (eventWrites, error) {
+	stagedCtx, staged := stageWrites(ctx, event)
+	if err := h.handleEvent(stagedCtx, event); err != nil {
+		return eventWrites{}, err
+	}
+	return *staged, nil
+}
+
+// complete buffers an event's writes, waiting for room in the batch, and
+// marks the event as completed.
+func (h *handler) complete(ctx context.Context, writes eventWrites) error {
+	if err := h.batch.add(ctx, writes); err != nil {
+		return err
+	}
+	h.tracker.Done(writes.event)
+	return nil
+}
+
+func (h *handler) handleEvent(ctx context.Context, event *models.Event) error {
+	switch {
+	case event.Commit != nil:
 		switch event.Commit.Collection {
 		case "app.bsky.feed.post":
-			var post apibsky.FeedPost
-			if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
-				return fmt.Errorf("failed to unmarshal post: %w", err)
+			if err := h.handlePostCommit(ctx, event); err != nil {
+				return err
+			}
+		case store.LikeCollection, store.RepostCollection:
+			if err := h.engagement.HandleCommit(ctx, event); err != nil {
+				return err
 			}
-			for _, f := range h.feeds {
-				if err := f.HandlePost(ctx, event, &post); err != nil {
+		case store.FollowCollection, store.BlockCollection:
+			if err := h.graph.HandleCommit(ctx, event); err != nil {
+				return err
+			}
+		}
+	case event.Account != nil:
+		if err := h.accounts.HandleAccount(ctx, event); err != nil {
+			return err
+		}
+	case event.Identity != nil:
+		if err := h.accounts.HandleIdentity(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *handler) handlePostCommit(ctx context.Context, event *models.Event) error {
+	switch event.Commit.Operation {
+	case models.CommitOperationCreate:
+		var post apibsky.FeedPost
+		if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
+			return fmt.Errorf("failed to unmarshal post: %w", err)
+		}
+		for _, f := range h.feeds {
+			if !f.wants(event) {
+				continue
+			}
+			if err := f.HandlePost(ctx, event, &post); err != nil {
+				return err
+			}
+		}
+	case models.CommitOperationUpdate:
+		var post apibsky.FeedPost
+		if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
+			return fmt.Errorf("failed to unmarshal post: %w", err)
+		}
+		for _, f := range h.feeds {
+			if !f.wants(event) {
+				continue
+			}
+			var err error
+			if u, ok := f.Feed.(PostUpdater); ok {
+				err = u.HandleUpdate(ctx, event, &post)
+			} else {
+				err = f.HandlePost(ctx, event, &post)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case models.CommitOperationDelete:
+		for _, f := range h.feeds {
+			if !f.wants(event) {
+				continue
+			}
+			if d, ok := f.Feed.(PostDeleter); ok {
+				if err := d.HandleDelete(ctx, event); err != nil {
 					return err
 				}
 			}
 		}
 	}
-
-	h.latestCursor = event.TimeUS
-
 	return nil
 }
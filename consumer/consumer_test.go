@@ -0,0 +1,289 @@
+package consumer_test
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	apibsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/consumer"
+	"jetstream-feed-generator/feedgen"
+	"jetstream-feed-generator/rules"
+	"jetstream-feed-generator/store"
+	"jetstream-feed-generator/store/sqlite"
+)
+
+func openStore(t *testing.T) store.Store {
+	t.Helper()
+	st, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	if _, err := st.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func postEvent(t *testing.T, timeUS int64, did, rkey, operation string, post *apibsky.FeedPost) *models.Event {
+	t.Helper()
+	commit := &models.Commit{
+		Operation:  operation,
+		Collection: "app.bsky.feed.post",
+		RKey:       rkey,
+	}
+	if post != nil {
+		record, err := json.Marshal(post)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit.Record = record
+	}
+	return &models.Event{Did: did, TimeUS: timeUS, Kind: models.EventKindCommit, Commit: commit}
+}
+
+// composerErrorPost matches both the composer-errors feed and a keyword rule.
+func composerErrorPost() *apibsky.FeedPost {
+	return &apibsky.FeedPost{
+		Text: "have a look at example com",
+		Embed: &apibsky.FeedPost_Embed{
+			EmbedExternal: &apibsky.EmbedExternal{
+				External: &apibsky.EmbedExternal_External{Uri: "https://example.com"},
+			},
+		},
+	}
+}
+
+func TestPostDeleteRemovesPostFromEveryFeed(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	feeds := []consumer.FeedConfig{
+		{Name: "composer-errors", Type: consumer.FeedTypeComposerErrors},
+		{Name: "example", Type: consumer.FeedTypeRules, Rule: rules.Rule{Keywords: []string{"example"}}},
+	}
+	h, err := consumer.NewTestHandler(ctx, feeds, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const did, rkey = "did:plc:author", "3kabc"
+	uri := "at://" + did + "/app.bsky.feed.post/" + rkey
+
+	handle(t, h, postEvent(t, 1000, did, rkey, models.CommitOperationCreate, composerErrorPost()))
+	for _, fc := range feeds {
+		if uris := pageURIs(t, st, fc.Name); len(uris) != 1 || uris[0] != uri {
+			t.Fatalf("feed %s after create: got %v, want [%s]", fc.Name, uris, uri)
+		}
+	}
+
+	handle(t, h, postEvent(t, 2000, did, rkey, models.CommitOperationDelete, nil))
+	for _, fc := range feeds {
+		if uris := pageURIs(t, st, fc.Name); len(uris) != 0 {
+			t.Errorf("feed %s after delete: got %v, want no posts", fc.Name, uris)
+		}
+	}
+}
+
+func TestPostUpdateReevaluatesPost(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	feeds := []consumer.FeedConfig{
+		{Name: "composer-errors", Type: consumer.FeedTypeComposerErrors},
+		{Name: "example", Type: consumer.FeedTypeRules, Rule: rules.Rule{Keywords: []string{"example"}}},
+	}
+	h, err := consumer.NewTestHandler(ctx, feeds, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const did, rkey = "did:plc:author", "3kabc"
+	uri := "at://" + did + "/app.bsky.feed.post/" + rkey
+	steps := []struct {
+		name string
+		op   string
+		post *apibsky.FeedPost
+		// want lists the feeds the post is in afterwards.
+		want []string
+	}{
+		{"created matching neither feed", models.CommitOperationCreate, &apibsky.FeedPost{Text: "hello"}, nil},
+		{"edited to match both", models.CommitOperationUpdate, composerErrorPost(), []string{"composer-errors", "example"}},
+		{"edited to match the rule only", models.CommitOperationUpdate, &apibsky.FeedPost{Text: "an example"}, []string{"example"}},
+		{"edited to match neither", models.CommitOperationUpdate, &apibsky.FeedPost{Text: "hello again"}, nil},
+	}
+	for i, step := range steps {
+		handle(t, h, postEvent(t, int64(1000*(i+1)), did, rkey, step.op, step.post))
+		for _, fc := range feeds {
+			var want []string
+			if slices.Contains(step.want, fc.Name) {
+				want = []string{uri}
+			}
+			if uris := pageURIs(t, st, fc.Name); !slices.Equal(uris, want) {
+				t.Errorf("%s: feed %s has %v, want %v", step.name, fc.Name, uris, want)
+			}
+		}
+	}
+	// A post still in a feed after an edit is at the time of the edit.
+	handle(t, h, postEvent(t, 9000, did, rkey, models.CommitOperationUpdate, composerErrorPost()))
+	posts, err := st.GetFeedPosts(ctx, store.GetFeedPostsParams{FeedName: "example", Before: 1 << 62, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].TimeUs != 9000 {
+		t.Errorf("posts after the last edit = %+v, want one at 9000", posts)
+	}
+}
+
+func accountEvent(timeUS int64, did string, active bool, status string) *models.Event {
+	account := &comatproto.SyncSubscribeRepos_Account{Did: did, Active: active}
+	if status != "" {
+		account.Status = &status
+	}
+	return &models.Event{Did: did, TimeUS: timeUS, Kind: models.EventKindAccount, Account: account}
+}
+
+func TestAccountStatusHidesRestoresAndPurgesPosts(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	h, err := consumer.NewTestHandler(ctx, []consumer.FeedConfig{
+		{Name: exampleFeed, Type: consumer.FeedTypeRules, Rule: rules.Rule{Keywords: []string{"example"}}},
+	}, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const author, other = "did:plc:author", "did:plc:other"
+	post := &apibsky.FeedPost{Text: "an example"}
+	handle(t, h,
+		postEvent(t, 1000, author, "a", models.CommitOperationCreate, post),
+		postEvent(t, 2000, other, "b", models.CommitOperationCreate, post),
+	)
+	authorURI := "at://" + author + "/app.bsky.feed.post/a"
+	otherURI := "at://" + other + "/app.bsky.feed.post/b"
+
+	steps := []struct {
+		name  string
+		event *models.Event
+		want  []string
+	}{
+		{"deactivated", accountEvent(3000, author, false, "deactivated"), []string{otherURI}},
+		// Account events can arrive out of order; an older one is ignored.
+		{"stale reactivation", accountEvent(2500, author, true, ""), []string{otherURI}},
+		{"reactivated", accountEvent(4000, author, true, ""), []string{otherURI, authorURI}},
+		{"taken down", accountEvent(5000, author, false, "takendown"), []string{otherURI}},
+		{"deleted", accountEvent(6000, author, false, "deleted"), []string{otherURI}},
+		// The posts of a deleted account are gone, so they don't come back.
+		{"reactivated after deletion", accountEvent(7000, author, true, ""), []string{otherURI}},
+	}
+	for _, step := range steps {
+		handle(t, h, step.event)
+		if uris := pageURIs(t, st, exampleFeed); !slices.Equal(uris, step.want) {
+			t.Errorf("%s: feed has %v, want %v", step.name, uris, step.want)
+		}
+	}
+}
+
+// engagementEvent is a like or repost of subject, or its delete if subject is
+// empty.
+func engagementEvent(t *testing.T, timeUS int64, did, collection, rkey, subject string) *models.Event {
+	t.Helper()
+	commit := &models.Commit{Operation: models.CommitOperationDelete, Collection: collection, RKey: rkey}
+	if subject != "" {
+		ref := &comatproto.RepoStrongRef{Uri: subject, Cid: "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"}
+		var record any = &apibsky.FeedLike{Subject: ref}
+		if collection == store.RepostCollection {
+			record = &apibsky.FeedRepost{Subject: ref}
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit.Operation, commit.Record = models.CommitOperationCreate, data
+	}
+	return &models.Event{Did: did, TimeUS: timeUS, Kind: models.EventKindCommit, Commit: commit}
+}
+
+func TestEngagementCountsLikesAndReposts(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	h, err := consumer.NewTestHandler(ctx, []consumer.FeedConfig{
+		{Name: exampleFeed, Type: consumer.FeedTypeRules, Rule: rules.Rule{Keywords: []string{"example"}}},
+	}, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const author = "did:plc:author"
+	inFeed := "at://" + author + "/app.bsky.feed.post/a"
+	notInFeed := "at://" + author + "/app.bsky.feed.post/b"
+	handle(t, h,
+		postEvent(t, 1000, author, "a", models.CommitOperationCreate, &apibsky.FeedPost{Text: "an example"}),
+		postEvent(t, 1001, author, "b", models.CommitOperationCreate, &apibsky.FeedPost{Text: "hello"}),
+	)
+
+	steps := []struct {
+		name    string
+		event   *models.Event
+		likes   int64
+		reposts int64
+		peak    int64
+	}{
+		{"liked", engagementEvent(t, 2000, "did:plc:x", store.LikeCollection, "l", inFeed), 1, 0, 1},
+		{"liked again", engagementEvent(t, 2001, "did:plc:y", store.LikeCollection, "l", inFeed), 2, 0, 2},
+		{"reposted", engagementEvent(t, 2002, "did:plc:x", store.RepostCollection, "r", inFeed), 2, 1, 3},
+		// Jetstream may deliver an event twice after a reconnect.
+		{"duplicate like", engagementEvent(t, 2000, "did:plc:x", store.LikeCollection, "l", inFeed), 2, 1, 3},
+		{"post outside feeds liked", engagementEvent(t, 2003, "did:plc:x", store.LikeCollection, "m", notInFeed), 2, 1, 3},
+		{"feed generator liked", engagementEvent(t, 2004, "did:plc:x", store.LikeCollection, "n", "at://"+author+"/app.bsky.feed.generator/a"), 2, 1, 3},
+		{"unliked", engagementEvent(t, 3000, "did:plc:x", store.LikeCollection, "l", ""), 1, 1, 3},
+		{"unliked again", engagementEvent(t, 3001, "did:plc:x", store.LikeCollection, "l", ""), 1, 1, 3},
+		{"uncounted like deleted", engagementEvent(t, 3002, "did:plc:x", store.LikeCollection, "m", ""), 1, 1, 3},
+		{"unreposted", engagementEvent(t, 3003, "did:plc:x", store.RepostCollection, "r", ""), 1, 0, 3},
+	}
+	for _, step := range steps {
+		handle(t, h, step.event)
+		posts, err := st.GetFeedPosts(ctx, store.GetFeedPostsParams{FeedName: exampleFeed, Before: 1 << 62, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) != 1 {
+			t.Fatalf("%s: feed has %d posts, want 1", step.name, len(posts))
+		}
+		p := posts[0]
+		if p.LikeCount != step.likes || p.RepostCount != step.reposts || p.PeakEngagement != step.peak {
+			t.Errorf("%s: likes, reposts, peak = %d, %d, %d, want %d, %d, %d", step.name,
+				p.LikeCount, p.RepostCount, p.PeakEngagement, step.likes, step.reposts, step.peak)
+		}
+	}
+}
+
+// handle handles events and checkpoints them.
+func handle(t *testing.T, h *consumer.TestHandler, events ...*models.Event) {
+	t.Helper()
+	ctx := context.Background()
+	for _, event := range events {
+		if err := h.HandleEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pageURIs returns the URIs of the posts on the first page of a feed.
+func pageURIs(t *testing.T, st store.Store, feed string) []string {
+	t.Helper()
+	dbFeed := feedgen.DbFeed{FeedName: feed, Store: st}
+	posts, _, err := dbFeed.GetPage(context.Background(), feed, "", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uris []string
+	for _, post := range posts {
+		uris = append(uris, post.Post)
+	}
+	return uris
+}
@@ -0,0 +1,131 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	jetstreamClient "github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+// cursorTracker computes the cursor that is safe to checkpoint when events
+// may complete out of order: the time of the newest event such that it and
+// every event received before it have been handled.
+type cursorTracker struct {
+	mu sync.Mutex
+	// pending holds events in the order they were received, up to and
+	// including the oldest one that hasn't completed.
+	pending []*trackedEvent
+	// byKey holds the started events that haven't completed, oldest first
+	// for each key. Events are found by key rather than by pointer, so Done
+	// works on any copy of an event, and the same event received twice,
+	// as after failing over to another Jetstream instance, is tracked
+	// twice.
+	byKey map[eventKey][]*trackedEvent
+	// complete and received are written under mu but may be read without
+	// it.
+	complete atomic.Int64
+	received atomic.Int64
+}
+
+type trackedEvent struct {
+	timeUS int64
+	done   bool
+}
+
+// eventKey identifies an event in the stream: Jetstream timestamps are
+// unique per instance, and an account's events are handled in order.
+type eventKey struct {
+	timeUS int64
+	did    string
+}
+
+func keyOf(event *models.Event) eventKey {
+	return eventKey{timeUS: event.TimeUS, did: event.Did}
+}
+
+func newCursorTracker(cursor int64) *cursorTracker {
+	t := &cursorTracker{
+		byKey: make(map[eventKey][]*trackedEvent),
+	}
+	t.complete.Store(cursor)
+	t.received.Store(cursor)
+	return t
+}
+
+// Start registers an event; it must be called in stream order.
+func (t *cursorTracker) Start(event *models.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	te := &trackedEvent{timeUS: event.TimeUS}
+	t.pending = append(t.pending, te)
+	key := keyOf(event)
+	t.byKey[key] = append(t.byKey[key], te)
+	t.received.Store(max(t.received.Load(), event.TimeUS))
+}
+
+// Done marks the oldest started copy of an event as handled and advances the
+// cursor past every contiguous completed event. Events that were never
+// started are ignored.
+func (t *cursorTracker) Done(event *models.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := keyOf(event)
+	started := t.byKey[key]
+	if len(started) == 0 {
+		return
+	}
+	te := started[0]
+	if len(started) == 1 {
+		delete(t.byKey, key)
+	} else {
+		started[0] = nil
+		t.byKey[key] = started[1:]
+	}
+	te.done = true
+	for len(t.pending) > 0 && t.pending[0].done {
+		// Events replayed after failing over to another Jetstream
+		// instance may be older than ones already completed.
+		t.complete.Store(max(t.complete.Load(), t.pending[0].timeUS))
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+	}
+}
+
+// Cursor returns the low-water mark of completed events.
+func (t *cursorTracker) Cursor() int64 {
+	return t.complete.Load()
+}
+
+// Received returns the time of the newest event started, or the initial
+// cursor if there are none; it is where a new connection resumes.
+func (t *cursorTracker) Received() int64 {
+	return t.received.Load()
+}
+
+// trackingScheduler registers events with a cursorTracker in stream order
+// before handing them to the underlying scheduler, and marks the consumer as
+// connected once events arrive.
+//
+// Events are handed on under ctx, the consumer's context, rather than the
+// connection's. Once started, an event has to complete even if its
+// connection is dropped: the cursor can't move past it until it does, and
+// the next connection resumes after it, so it isn't received again.
+type trackingScheduler struct {
+	jetstreamClient.Scheduler
+	ctx     context.Context
+	tracker *cursorTracker
+	status  *Status
+}
+
+func (s *trackingScheduler) AddWork(ctx context.Context, repo string, event *models.Event) error {
+	if ctx.Err() != nil {
+		// Read after the connection was abandoned; it is received again
+		// on the next connection.
+		return nil
+	}
+	s.status.setConnected(true)
+	s.tracker.Start(event)
+	return s.Scheduler.AddWork(s.ctx, repo, event)
+}
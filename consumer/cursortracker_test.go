@@ -0,0 +1,174 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/jetstream/pkg/client/schedulers/sequential"
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+func event(timeUS int64, did string) *models.Event {
+	return &models.Event{TimeUS: timeUS, Did: did}
+}
+
+func TestCursorTracker(t *testing.T) {
+	type step struct {
+		// start or done, with the event's time and DID.
+		op     string
+		timeUS int64
+		did    string
+		// cursor is the low-water mark after the step.
+		cursor int64
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"in order", []step{
+			{"start", 10, "a", 0},
+			{"start", 20, "b", 0},
+			{"done", 10, "a", 10},
+			{"done", 20, "b", 20},
+		}},
+		{"out of order", []step{
+			{"start", 10, "a", 0},
+			{"start", 20, "b", 0},
+			{"start", 30, "c", 0},
+			{"done", 30, "c", 0},
+			{"done", 20, "b", 0},
+			{"done", 10, "a", 30},
+		}},
+		{"gap holds the cursor", []step{
+			{"start", 10, "a", 0},
+			{"start", 20, "b", 0},
+			{"start", 30, "c", 0},
+			{"done", 10, "a", 10},
+			{"done", 30, "c", 10},
+			{"done", 20, "b", 30},
+		}},
+		{"same time from different accounts", []step{
+			{"start", 10, "a", 0},
+			{"start", 10, "b", 0},
+			{"done", 10, "b", 0},
+			{"done", 10, "a", 10},
+		}},
+		{"same event received twice", []step{
+			{"start", 10, "a", 0},
+			{"start", 20, "b", 0},
+			{"start", 10, "a", 0},
+			{"done", 10, "a", 10},
+			{"done", 20, "b", 20},
+			{"done", 10, "a", 20},
+		}},
+		{"unknown event is ignored", []step{
+			{"start", 10, "a", 0},
+			{"done", 10, "b", 0},
+			{"done", 5, "a", 0},
+			{"done", 10, "a", 10},
+			{"done", 10, "a", 10},
+		}},
+		{"events replayed after failover don't move the cursor back", []step{
+			{"start", 100, "a", 0},
+			{"done", 100, "a", 100},
+			{"start", 90, "b", 100},
+			{"start", 95, "c", 100},
+			{"done", 95, "c", 100},
+			{"done", 90, "b", 100},
+			{"start", 110, "d", 100},
+			{"done", 110, "d", 110},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newCursorTracker(0)
+			for i, s := range tt.steps {
+				// Done gets a copy of the event, as a scheduler or a
+				// reprocessed dead letter might pass.
+				switch s.op {
+				case "start":
+					tracker.Start(event(s.timeUS, s.did))
+				case "done":
+					tracker.Done(event(s.timeUS, s.did))
+				}
+				if got := tracker.Cursor(); got != s.cursor {
+					t.Fatalf("step %d (%s %d %s): cursor = %d, want %d", i, s.op, s.timeUS, s.did, got, s.cursor)
+				}
+			}
+		})
+	}
+}
+
+func TestCursorTrackerReceived(t *testing.T) {
+	tracker := newCursorTracker(50)
+	if got := tracker.Cursor(); got != 50 {
+		t.Errorf("initial cursor = %d, want 50", got)
+	}
+	if got := tracker.Received(); got != 50 {
+		t.Errorf("initial received = %d, want 50", got)
+	}
+	tracker.Start(event(100, "a"))
+	tracker.Start(event(80, "b"))
+	if got := tracker.Received(); got != 100 {
+		t.Errorf("received = %d, want 100", got)
+	}
+	if got := tracker.Cursor(); got != 50 {
+		t.Errorf("cursor = %d, want 50 before anything completes", got)
+	}
+}
+
+func TestTrackingSchedulerCompletesEventsOfDroppedConnections(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	batch := NewBatchWriter(st, slog.Default(), 1)
+	for i := range maxBufferedBatches {
+		if err := batch.add(ctx, postWrites(int64(i+1), "did:plc:a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &handler{batch: batch, accounts: newAccountHandler(slog.Default(), batch), tracker: newCursorTracker(0)}
+	scheduler := &trackingScheduler{
+		Scheduler: sequential.NewScheduler("test", slog.Default(), h.HandleEvent),
+		ctx:       ctx,
+		tracker:   h.tracker,
+		status:    NewStatus(),
+	}
+
+	account := &models.Event{
+		Did:     "did:plc:b",
+		TimeUS:  100,
+		Kind:    models.EventKindAccount,
+		Account: &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:b", Active: true},
+	}
+	connCtx, dropConnection := context.WithCancel(ctx)
+	added := make(chan error, 1)
+	go func() {
+		added <- scheduler.AddWork(connCtx, account.Did, account)
+	}()
+	// The connection is dropped while the event waits for room in the
+	// buffer, which it still gets.
+	time.Sleep(50 * time.Millisecond)
+	dropConnection()
+	select {
+	case err := <-added:
+		t.Fatalf("AddWork returned %v before a flush made room", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := batch.Flush(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AddWork still blocked after a flush")
+	}
+	if got := h.tracker.Cursor(); got != account.TimeUS {
+		t.Errorf("cursor = %d, want %d", got, account.TimeUS)
+	}
+}
@@ -0,0 +1,211 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/metrics"
+	"jetstream-feed-generator/store"
+)
+
+// Failure policies for events the consumer fails to handle.
+const (
+	// FailureSkip records the event as a dead letter and moves on.
+	FailureSkip = "skip"
+	// FailureRetry retries handling the event, or applying its writes if
+	// those are what failed, a few times before recording it as a dead
+	// letter.
+	FailureRetry = "retry"
+	// FailureStop stops the consumer with an error wrapping ErrStopped,
+	// which takes the whole process down. The event isn't checkpointed, so
+	// it is handled again when the process is restarted.
+	FailureStop = "stop"
+)
+
+// ErrStopped is wrapped by the error RunConsumer returns when FailureStop
+// stopped it.
+var ErrStopped = errors.New("stopped by failure policy")
+
+// retryDelay is the wait before the first retry; it doubles for each one
+// after that.
+const retryDelay = 100 * time.Millisecond
+
+// failureHandler applies the failure policy to events the handler failed,
+// and to events whose writes failed to apply when flushed.
+type failureHandler struct {
+	logger     *slog.Logger
+	policy     string
+	maxRetries int
+	// stop is called with the error when an event fails under FailureStop.
+	stop func(error)
+}
+
+func (fh *failureHandler) handle(ctx context.Context, h *handler, event *models.Event, err error) error {
+	attempts := 1
+	if fh.policy == FailureRetry {
+		for ; attempts <= fh.maxRetries; attempts++ {
+			select {
+			case <-ctx.Done():
+				// Leave the event incomplete; it is handled again on restart.
+				return ctx.Err()
+			case <-time.After(retryDelay << (attempts - 1)):
+			}
+			var writes eventWrites
+			writes, err = h.stageEvent(ctx, event)
+			if err == nil {
+				return h.complete(ctx, writes)
+			}
+		}
+	}
+	metrics.HandlerErrors.Inc()
+
+	if fh.policy == FailureStop {
+		err = fmt.Errorf("%w: failed to handle event from %s at %d: %w", ErrStopped, event.Did, event.TimeUS, err)
+		fh.stop(err)
+		return err
+	}
+
+	deadLetter, dlErr := fh.deadLetter(event, err, attempts)
+	if dlErr != nil {
+		fh.stop(dlErr)
+		return dlErr
+	}
+	return h.complete(ctx, eventWrites{event: event, ops: []writeOp{deadLetter}})
+}
+
+// writeFailed applies the failure policy to an event whose writes failed to
+// apply. Under FailureRetry, retry is called to check whether the writes
+// apply now, and writeFailed returns nil if they do; otherwise it returns
+// the dead letter to write in their place.
+func (fh *failureHandler) writeFailed(ctx context.Context, event *models.Event, writeErr error, retry func() error) (writeOp, error) {
+	attempts := 1
+	if fh.policy == FailureRetry {
+		for ; attempts <= fh.maxRetries; attempts++ {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(retryDelay << (attempts - 1)):
+			}
+			if writeErr = retry(); writeErr == nil {
+				return nil, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+	}
+	metrics.HandlerErrors.Inc()
+
+	if fh.policy == FailureStop {
+		err := fmt.Errorf("%w: failed to write event from %s at %d: %w", ErrStopped, event.Did, event.TimeUS, writeErr)
+		fh.stop(err)
+		return nil, err
+	}
+	return fh.deadLetter(event, writeErr, attempts)
+}
+
+// deadLetter returns the write that records the event as a dead letter, to
+// be applied in the same batch as the checkpoint that moves past it.
+func (fh *failureHandler) deadLetter(event *models.Event, failErr error, attempts int) (writeOp, error) {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	letter := store.DeadLetter{
+		TimeUs:   event.TimeUS,
+		Did:      event.Did,
+		Event:    eventJSON,
+		Error:    failErr.Error(),
+		Attempts: int64(attempts),
+		FailedAt: time.Now(),
+	}
+	metrics.DeadLetters.Inc()
+	fh.logger.Warn("failed to handle event, recorded as dead letter", "did", event.Did,
+		"time_us", event.TimeUS, "attempts", attempts, "error", failErr)
+	return func(ctx context.Context, w store.Writer) error {
+		return w.InsertDeadLetter(ctx, letter)
+	}, nil
+}
+
+type ReprocessConfig struct {
+	// Limit bounds the number of dead letters reprocessed; zero means all.
+	Limit     int
+	BatchSize int
+	Feeds     []FeedConfig
+	Store     store.Store
+}
+
+// ReprocessDeadLetters runs dead letters through the configured feeds again,
+// oldest first. Like Replay, every feed sees every event and cursors are left
+// alone. Dead letters that succeed are deleted; ones that fail again have
+// their error and attempt count updated.
+func ReprocessDeadLetters(ctx context.Context, config ReprocessConfig) (succeeded, failed int, err error) {
+	logger := slog.With("component", "reprocess")
+	batch := NewBatchWriter(config.Store, logger, config.BatchSize)
+	handler, err := newHandler(ctx, config.Feeds, logger, config.Store, batch)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	const pageSize = 100
+	var afterID int64
+	for config.Limit == 0 || succeeded+failed < config.Limit {
+		limit := pageSize
+		if config.Limit > 0 {
+			limit = min(limit, config.Limit-succeeded-failed)
+		}
+		letters, err := config.Store.ListDeadLetters(ctx, afterID, limit)
+		if err != nil {
+			return succeeded, failed, fmt.Errorf("failed to list dead letters: %w", err)
+		}
+		if len(letters) == 0 {
+			break
+		}
+		for _, letter := range letters {
+			afterID = letter.ID
+			var event models.Event
+			var writes eventWrites
+			handleErr := json.Unmarshal(letter.Event, &event)
+			if handleErr == nil {
+				writes, handleErr = handler.stageEvent(ctx, &event)
+			}
+			if errors.Is(handleErr, context.Canceled) {
+				return succeeded, failed, handleErr
+			}
+			id := letter.ID
+			if handleErr != nil {
+				failed++
+				logger.Warn("dead letter failed again", "id", id, "did", letter.Did, "error", handleErr)
+				errorText, failedAt := handleErr.Error(), time.Now()
+				writes = eventWrites{ops: []writeOp{func(ctx context.Context, w store.Writer) error {
+					return w.UpdateDeadLetterFailure(ctx, id, errorText, failedAt)
+				}}}
+			} else {
+				succeeded++
+				writes.ops = append(writes.ops, func(ctx context.Context, w store.Writer) error {
+					return w.DeleteDeadLetter(ctx, id)
+				})
+			}
+			if err := batch.add(ctx, writes); err != nil {
+				return succeeded, failed, err
+			}
+			select {
+			case <-batch.Full():
+				if err := batch.Flush(ctx, nil); err != nil {
+					return succeeded, failed, fmt.Errorf("failed to flush batch: %w", err)
+				}
+			default:
+			}
+		}
+		// Each page's writes and dead letter updates commit together.
+		if err := batch.Flush(ctx, nil); err != nil {
+			return succeeded, failed, fmt.Errorf("failed to flush batch: %w", err)
+		}
+	}
+	return succeeded, failed, nil
+}
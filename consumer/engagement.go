@@ -0,0 +1,97 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	apibsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/store"
+)
+
+// engagementHandler counts likes and reposts of posts in feeds, so feeds can
+// be ranked by engagement. Only records whose subject is already in some
+// feed are kept, which lets their deletes be counted too; the store ignores
+// the rest.
+type engagementHandler struct {
+	logger *slog.Logger
+	batch  *BatchWriter
+}
+
+func newEngagementHandler(logger *slog.Logger, batch *BatchWriter) *engagementHandler {
+	return &engagementHandler{
+		logger: logger,
+		batch:  batch,
+	}
+}
+
+func (e *engagementHandler) HandleCommit(ctx context.Context, event *models.Event) error {
+	commit := event.Commit
+	switch commit.Operation {
+	case models.CommitOperationCreate:
+		subject, err := engagementSubject(commit)
+		if err != nil {
+			return err
+		}
+		if subject == nil {
+			return nil
+		}
+		engagement := store.Engagement{
+			Did:         event.Did,
+			Collection:  commit.Collection,
+			Rkey:        commit.RKey,
+			SubjectDid:  subject.Authority().String(),
+			SubjectRkey: subject.RecordKey().String(),
+		}
+		return e.batch.Add(ctx, func(ctx context.Context, w store.Writer) error {
+			if err := w.AddEngagement(ctx, engagement); err != nil {
+				return fmt.Errorf("failed to add engagement: %w", err)
+			}
+			return nil
+		})
+	case models.CommitOperationDelete:
+		did, collection, rkey := event.Did, commit.Collection, commit.RKey
+		return e.batch.Add(ctx, func(ctx context.Context, w store.Writer) error {
+			if err := w.DeleteEngagement(ctx, did, collection, rkey); err != nil {
+				return fmt.Errorf("failed to delete engagement: %w", err)
+			}
+			return nil
+		})
+	}
+	// Likes and reposts aren't edited in practice, and an edit couldn't
+	// change the subject without breaking the strong ref.
+	return nil
+}
+
+// engagementSubject returns the post a like or repost refers to, or nil if
+// it refers to something other than a post, such as a feed generator.
+func engagementSubject(commit *models.Commit) (*syntax.ATURI, error) {
+	var ref *comatproto.RepoStrongRef
+	switch commit.Collection {
+	case store.LikeCollection:
+		var like apibsky.FeedLike
+		if err := json.Unmarshal(commit.Record, &like); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal like: %w", err)
+		}
+		ref = like.Subject
+	case store.RepostCollection:
+		var repost apibsky.FeedRepost
+		if err := json.Unmarshal(commit.Record, &repost); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal repost: %w", err)
+		}
+		ref = repost.Subject
+	}
+	if ref == nil {
+		return nil, nil
+	}
+	uri, err := syntax.ParseATURI(ref.Uri)
+	if err != nil || uri.Collection() != "app.bsky.feed.post" {
+		// Malformed subjects can't match a stored post.
+		return nil, nil
+	}
+	return &uri, nil
+}
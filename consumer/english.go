@@ -56,10 +56,6 @@ func (f *EnglishTextFeed) LatestCursor(ctx context.Context) (int64, error) {
 	return 0, nil
 }
 
-func (f *EnglishTextFeed) SaveCursor(ctx context.Context, cursor int64) error {
-	return nil
-}
-
 func NewEnglishTextFeed(name string, logger *slog.Logger) *EnglishTextFeed {
 	feedLogger := logger.With("feed", name)
 	return &EnglishTextFeed{name, feedLogger}
@@ -0,0 +1,37 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/store"
+)
+
+// TestHandler exposes the event handler used by RunConsumer to the tests in
+// consumer_test, which can't be in this package because they use feedgen.
+type TestHandler struct {
+	h     *handler
+	batch *BatchWriter
+}
+
+func NewTestHandler(ctx context.Context, feeds []FeedConfig, st store.Store) (*TestHandler, error) {
+	batch := NewBatchWriter(st, slog.Default(), 100)
+	h, err := newHandler(ctx, feeds, slog.Default(), st, batch)
+	if err != nil {
+		return nil, err
+	}
+	h.tracker = newCursorTracker(0)
+	return &TestHandler{h: h, batch: batch}, nil
+}
+
+// HandleEvent handles an event as if it had been received from Jetstream.
+func (t *TestHandler) HandleEvent(ctx context.Context, event *models.Event) error {
+	t.h.tracker.Start(event)
+	return t.h.HandleEvent(ctx, event)
+}
+
+// Flush checkpoints the handled events.
+func (t *TestHandler) Flush(ctx context.Context) error {
+	return t.batch.Flush(ctx, t.h.feedCursors(t.h.tracker.Cursor()))
+}
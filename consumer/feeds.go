@@ -0,0 +1,65 @@
+package consumer
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"jetstream-feed-generator/rules"
+	"jetstream-feed-generator/store"
+)
+
+// Feed types that can be selected in the feeds config.
+const (
+	FeedTypeComposerErrors = "composer-errors"
+	FeedTypeRules          = "rules"
+)
+
+// FeedConfig selects the implementation for one feed served by the consumer.
+type FeedConfig struct {
+	Name string
+	Type string
+	// Rule is only used by FeedTypeRules.
+	Rule      rules.Rule
+	Retention Retention
+}
+
+// Retention limits how many posts a feed keeps. Zero values are unlimited.
+type Retention struct {
+	MaxAge   time.Duration
+	MaxPosts int64
+}
+
+// IsSet reports whether any limit is configured.
+func (r Retention) IsSet() bool {
+	return r.MaxAge > 0 || r.MaxPosts > 0
+}
+
+func (fc FeedConfig) Validate() error {
+	if fc.Retention.MaxAge < 0 || fc.Retention.MaxPosts < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+	switch fc.Type {
+	case FeedTypeComposerErrors:
+		return nil
+	case FeedTypeRules:
+		if _, err := fc.Rule.Compile(); err != nil {
+			return fmt.Errorf("invalid rule: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown feed type %q", fc.Type)
+	}
+}
+
+// NewFeed builds the Feed implementation selected by the config.
+func NewFeed(fc FeedConfig, logger *slog.Logger, st store.Store, batch *BatchWriter) (Feed, error) {
+	switch fc.Type {
+	case FeedTypeComposerErrors:
+		return NewComposerErrorsFeed(fc.Name, logger, st, batch), nil
+	case FeedTypeRules:
+		return NewRuleFeed(fc.Name, fc.Rule, logger, st, batch)
+	default:
+		return nil, fmt.Errorf("unknown feed type %q", fc.Type)
+	}
+}
@@ -0,0 +1,71 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/metrics"
+	"jetstream-feed-generator/store"
+)
+
+// feedStore implements the cursor and feed_posts bookkeeping shared by feeds
+// that keep their matching posts in the database. Feeds embed it and decide
+// which posts to add. Writes go through the consumer's BatchWriter.
+type feedStore struct {
+	name   string
+	logger *slog.Logger
+	store  store.Store
+	batch  *BatchWriter
+}
+
+func newFeedStore(name string, logger *slog.Logger, st store.Store, batch *BatchWriter) feedStore {
+	return feedStore{
+		name:   name,
+		logger: logger.With("feed", name),
+		store:  st,
+		batch:  batch,
+	}
+}
+
+func (f *feedStore) Name() string {
+	return f.name
+}
+
+func (f *feedStore) Initialize(ctx context.Context) error {
+	if err := f.store.UpsertFeed(ctx, f.Name()); err != nil {
+		return fmt.Errorf("failed to upsert feed: %v", err)
+	}
+	return nil
+}
+
+func (f *feedStore) LatestCursor(ctx context.Context) (int64, error) {
+	return f.store.FeedCursor(ctx, f.Name())
+}
+
+func (f *feedStore) HandleDelete(ctx context.Context, event *models.Event) error {
+	did, rkey := event.Did, event.Commit.RKey
+	return f.batch.Add(ctx, func(ctx context.Context, w store.Writer) error {
+		if err := w.DeleteFeedPost(ctx, f.name, did, rkey); err != nil {
+			return fmt.Errorf("failed to delete feed post: %w", err)
+		}
+		return nil
+	})
+}
+
+func (f *feedStore) addPost(ctx context.Context, event *models.Event) error {
+	metrics.FeedPostsMatched.WithLabelValues(f.name).Inc()
+	post := store.FeedPost{
+		FeedName: f.Name(),
+		TimeUs:   event.TimeUS,
+		Did:      event.Did,
+		Rkey:     event.Commit.RKey,
+	}
+	return f.batch.Add(ctx, func(ctx context.Context, w store.Writer) error {
+		if err := w.UpsertFeedPost(ctx, post); err != nil {
+			return fmt.Errorf("failed to upsert feed post: %w", err)
+		}
+		return nil
+	})
+}
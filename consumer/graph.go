@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	apibsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/store"
+)
+
+// graphHandler records follows and blocks, so feeds can be personalized
+// for the viewer. Records are kept whole so their deletes can be applied.
+type graphHandler struct {
+	logger *slog.Logger
+	batch  *BatchWriter
+}
+
+func newGraphHandler(logger *slog.Logger, batch *BatchWriter) *graphHandler {
+	return &graphHandler{
+		logger: logger,
+		batch:  batch,
+	}
+}
+
+func (g *graphHandler) HandleCommit(ctx context.Context, event *models.Event) error {
+	commit := event.Commit
+	switch commit.Operation {
+	case models.CommitOperationCreate:
+		subject, err := graphSubject(commit)
+		if err != nil {
+			return err
+		}
+		if subject == "" {
+			return nil
+		}
+		edge := store.GraphEdge{
+			Did:        event.Did,
+			Collection: commit.Collection,
+			Rkey:       commit.RKey,
+			SubjectDid: subject,
+		}
+		return g.batch.Add(ctx, func(ctx context.Context, w store.Writer) error {
+			if err := w.AddGraphEdge(ctx, edge); err != nil {
+				return fmt.Errorf("failed to add graph edge: %w", err)
+			}
+			return nil
+		})
+	case models.CommitOperationDelete:
+		did, collection, rkey := event.Did, commit.Collection, commit.RKey
+		return g.batch.Add(ctx, func(ctx context.Context, w store.Writer) error {
+			if err := w.DeleteGraphEdge(ctx, did, collection, rkey); err != nil {
+				return fmt.Errorf("failed to delete graph edge: %w", err)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+// graphSubject returns the DID a follow or block refers to, or "" if it
+// isn't a valid DID.
+func graphSubject(commit *models.Commit) (string, error) {
+	var subject string
+	switch commit.Collection {
+	case store.FollowCollection:
+		var follow apibsky.GraphFollow
+		if err := json.Unmarshal(commit.Record, &follow); err != nil {
+			return "", fmt.Errorf("failed to unmarshal follow: %w", err)
+		}
+		subject = follow.Subject
+	case store.BlockCollection:
+		var block apibsky.GraphBlock
+		if err := json.Unmarshal(commit.Record, &block); err != nil {
+			return "", fmt.Errorf("failed to unmarshal block: %w", err)
+		}
+		subject = block.Subject
+	}
+	if _, err := syntax.ParseDID(subject); err != nil {
+		return "", nil
+	}
+	return subject, nil
+}
@@ -0,0 +1,262 @@
+package consumer_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/consumer"
+	"jetstream-feed-generator/jetstreamtest"
+	"jetstream-feed-generator/rules"
+	"jetstream-feed-generator/store"
+)
+
+const exampleFeed = "example"
+
+// runConsumer runs the consumer against srv with the example feed until the
+// returned stop function is called, which returns RunConsumer's error.
+func runConsumer(t *testing.T, srv *jetstreamtest.Server, st store.Store, status *consumer.Status) (stop func() error) {
+	t.Helper()
+	return runConsumerConfig(t, testConfig(srv, st, status))
+}
+
+func testConfig(srv *jetstreamtest.Server, st store.Store, status *consumer.Status) consumer.Config {
+	return consumer.Config{
+		JetstreamURLs:    []string{srv.URL},
+		BackfillLookback: time.Hour,
+		BatchSize:        100,
+		FlushInterval:    10 * time.Millisecond,
+		StatsInterval:    time.Minute,
+		FailurePolicy:    consumer.FailureSkip,
+		Feeds: []consumer.FeedConfig{
+			{Name: exampleFeed, Type: consumer.FeedTypeRules, Rule: rules.Rule{Keywords: []string{"example"}}},
+		},
+		Store:  st,
+		Status: status,
+	}
+}
+
+// runConsumerConfig is runConsumer with a config of the test's own.
+func runConsumerConfig(t *testing.T, config consumer.Config) (stop func() error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consumer.RunConsumer(ctx, config)
+	}()
+	stopped := false
+	stop = func() error {
+		t.Helper()
+		if stopped {
+			return nil
+		}
+		stopped = true
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("consumer didn't shut down")
+			return nil
+		}
+	}
+	t.Cleanup(func() { stop() })
+	return stop
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForCheckpoint waits until the consumer has checkpointed event.
+func waitForCheckpoint(t *testing.T, status *consumer.Status, event *models.Event) {
+	t.Helper()
+	waitFor(t, "checkpoint", func() bool {
+		return status.FeedCursors()[exampleFeed] >= event.TimeUS
+	})
+}
+
+func examplePost(t *testing.T, rkey string) *models.Event {
+	t.Helper()
+	event := postEvent(t, 0, "did:plc:author", rkey, models.CommitOperationCreate, composerErrorPost())
+	return event
+}
+
+func TestConsumerReconnectsAfterDrop(t *testing.T) {
+	srv := jetstreamtest.NewServer()
+	defer srv.Close()
+	st := openStore(t)
+	status := consumer.NewStatus()
+	runConsumer(t, srv, st, status)
+
+	waitFor(t, "connection", func() bool { return srv.Connected() == 1 })
+	first := examplePost(t, "first")
+	srv.AddEvents(first)
+	waitForCheckpoint(t, status, first)
+
+	srv.DropConnections()
+	waitFor(t, "reconnection", func() bool { return len(srv.Subscriptions()) == 2 && srv.Connected() == 1 })
+	subs := srv.Subscriptions()
+	if subs[1].Cursor == nil || *subs[1].Cursor != first.TimeUS {
+		t.Fatalf("reconnected with cursor %v, want %d", subs[1].Cursor, first.TimeUS)
+	}
+
+	second := examplePost(t, "second")
+	srv.AddEvents(second)
+	waitForCheckpoint(t, status, second)
+	if n := len(feedPosts(t, st)); n != 2 {
+		t.Errorf("feed has %d posts, want 2", n)
+	}
+}
+
+func TestConsumerResumesFromCheckpoint(t *testing.T) {
+	srv := jetstreamtest.NewServer()
+	defer srv.Close()
+	st := openStore(t)
+
+	status := consumer.NewStatus()
+	stop := runConsumer(t, srv, st, status)
+	waitFor(t, "connection", func() bool { return srv.Connected() == 1 })
+	events := []*models.Event{examplePost(t, "a"), examplePost(t, "b")}
+	srv.AddEvents(events...)
+	waitForCheckpoint(t, status, events[1])
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := st.FeedCursor(context.Background(), exampleFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != events[1].TimeUS {
+		t.Fatalf("saved cursor = %d, want %d", saved, events[1].TimeUS)
+	}
+
+	// Events added while the consumer is down are picked up on restart.
+	missed := examplePost(t, "c")
+	srv.AddEvents(missed)
+	status = consumer.NewStatus()
+	runConsumer(t, srv, st, status)
+	waitForCheckpoint(t, status, missed)
+
+	subs := srv.Subscriptions()
+	if len(subs) != 2 {
+		t.Fatalf("%d subscriptions, want 2", len(subs))
+	}
+	if subs[1].Cursor == nil || *subs[1].Cursor != saved {
+		t.Errorf("restarted with cursor %v, want the saved cursor %d", subs[1].Cursor, saved)
+	}
+	if n := len(feedPosts(t, st)); n != 3 {
+		t.Errorf("feed has %d posts, want 3", n)
+	}
+}
+
+func TestConsumerShutsDownOnCancel(t *testing.T) {
+	srv := jetstreamtest.NewServer()
+	defer srv.Close()
+	st := openStore(t)
+	status := consumer.NewStatus()
+	stop := runConsumer(t, srv, st, status)
+
+	waitFor(t, "connection", func() bool { return srv.Connected() == 1 })
+	event := examplePost(t, "a")
+	srv.AddEvents(event)
+	waitFor(t, "event", func() bool { return status.Connected() })
+
+	if err := stop(); err != nil {
+		t.Fatalf("RunConsumer = %v, want nil on cancel", err)
+	}
+	if status.Connected() {
+		t.Error("status still connected after shutdown")
+	}
+	// The final checkpoint covers every event handled before the shutdown.
+	saved, err := st.FeedCursor(context.Background(), exampleFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != event.TimeUS {
+		t.Errorf("saved cursor = %d, want %d", saved, event.TimeUS)
+	}
+}
+
+func TestConsumerResumesEachFeedFromItsOwnCursor(t *testing.T) {
+	srv := jetstreamtest.NewServer()
+	defer srv.Close()
+	st := openStore(t)
+	ctx := context.Background()
+
+	// The example feed has handled everything up to saved; a feed added
+	// since has no cursor and starts an hour back.
+	now := time.Now()
+	saved := now.Add(-20 * time.Minute).UnixMicro()
+	if err := st.UpsertFeed(ctx, exampleFeed); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.InTx(ctx, func(w store.Writer) error { return w.UpdateFeedCursor(ctx, exampleFeed, saved) }); err != nil {
+		t.Fatal(err)
+	}
+	tooOld := postEvent(t, now.Add(-2*time.Hour).UnixMicro(), "did:plc:author", "too-old", models.CommitOperationCreate, composerErrorPost())
+	seen := postEvent(t, now.Add(-30*time.Minute).UnixMicro(), "did:plc:author", "seen", models.CommitOperationCreate, composerErrorPost())
+	atCursor := postEvent(t, saved, "did:plc:author", "at-cursor", models.CommitOperationCreate, composerErrorPost())
+	unseen := postEvent(t, now.Add(-10*time.Minute).UnixMicro(), "did:plc:author", "unseen", models.CommitOperationCreate, composerErrorPost())
+	srv.AddEvents(tooOld, seen, atCursor, unseen)
+
+	const addedFeed = "added"
+	status := consumer.NewStatus()
+	config := testConfig(srv, st, status)
+	config.Feeds = append(config.Feeds, consumer.FeedConfig{
+		Name: addedFeed, Type: consumer.FeedTypeRules, Rule: rules.Rule{Keywords: []string{"example"}},
+	})
+	runConsumerConfig(t, config)
+	waitFor(t, "checkpoint", func() bool {
+		cursors := status.FeedCursors()
+		return cursors[exampleFeed] >= unseen.TimeUS && cursors[addedFeed] >= unseen.TimeUS
+	})
+
+	// The stream starts at the earlier cursor: the new feed's lookback.
+	subs := srv.Subscriptions()
+	if len(subs) != 1 || subs[0].Cursor == nil {
+		t.Fatalf("subscriptions = %+v, want one with a cursor", subs)
+	}
+	from, to := now.Add(-time.Hour).UnixMicro(), time.Now().Add(-time.Hour).UnixMicro()
+	if cursor := *subs[0].Cursor; cursor < from || cursor > to {
+		t.Errorf("subscribed from %d, want the lookback window between %d and %d", cursor, from, to)
+	}
+
+	uri := func(event *models.Event) string {
+		return "at://" + event.Did + "/app.bsky.feed.post/" + event.Commit.RKey
+	}
+	check := func(feed string, want ...*models.Event) {
+		t.Helper()
+		var wantURIs []string
+		for _, event := range want {
+			wantURIs = append(wantURIs, uri(event))
+		}
+		if got := pageURIs(t, st, feed); !slices.Equal(got, wantURIs) {
+			t.Errorf("%s feed = %q, want %q", feed, got, wantURIs)
+		}
+	}
+	// The example feed skips events up to and including its saved cursor.
+	check(exampleFeed, unseen)
+	check(addedFeed, unseen, atCursor, seen)
+}
+
+func feedPosts(t *testing.T, st store.Store) []store.FeedPost {
+	t.Helper()
+	posts, err := st.GetFeedPosts(context.Background(), store.GetFeedPostsParams{
+		FeedName: exampleFeed, Before: time.Now().Add(time.Hour).UnixMicro(), Limit: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return posts
+}
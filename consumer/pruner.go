@@ -0,0 +1,126 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"jetstream-feed-generator/metrics"
+	"jetstream-feed-generator/store"
+)
+
+// pruneBatchPause is how long the pruner yields between delete batches, so
+// the consumer's own writes are never queued behind a long prune.
+const pruneBatchPause = 50 * time.Millisecond
+
+// pruner enforces each feed's retention policy in small batches, then lets
+// the store reclaim the freed space.
+type pruner struct {
+	logger    *slog.Logger
+	store     store.Store
+	feeds     []FeedConfig
+	interval  time.Duration
+	batchSize int
+}
+
+func (p *pruner) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.prune(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *pruner) prune(ctx context.Context) {
+	for _, fc := range p.feeds {
+		if !fc.Retention.IsSet() {
+			continue
+		}
+		if err := p.pruneFeed(ctx, fc); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.logger.Error("failed to prune feed", "feed", fc.Name, "error", err)
+		}
+	}
+	if err := p.pruneEngagements(ctx); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		p.logger.Error("failed to prune engagements", "error", err)
+	}
+	if err := p.store.Maintain(ctx); err != nil && ctx.Err() == nil {
+		p.logger.Warn("database maintenance failed", "error", err)
+	}
+}
+
+func (p *pruner) pruneFeed(ctx context.Context, fc FeedConfig) error {
+	var cutoff int64
+	if fc.Retention.MaxAge > 0 {
+		cutoff = time.Now().Add(-fc.Retention.MaxAge).UnixMicro()
+	}
+	if fc.Retention.MaxPosts > 0 {
+		countCutoff, err := p.store.FeedPostCutoff(ctx, fc.Name, fc.Retention.MaxPosts)
+		if err != nil {
+			return err
+		}
+		cutoff = max(cutoff, countCutoff)
+	}
+	if cutoff == 0 {
+		return nil
+	}
+
+	var total int64
+	defer func() {
+		if total > 0 {
+			p.logger.Info("pruned feed posts", "feed", fc.Name, "deleted", total, "cutoff", cutoff)
+		}
+	}()
+	for {
+		deleted, err := p.store.PruneFeedPosts(ctx, fc.Name, cutoff, p.batchSize)
+		if err != nil {
+			return err
+		}
+		total += deleted
+		metrics.FeedPostsPruned.WithLabelValues(fc.Name).Add(float64(deleted))
+		if deleted < int64(p.batchSize) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pruneBatchPause):
+		}
+	}
+}
+
+// pruneEngagements deletes the likes and reposts of posts that have left
+// every feed, through pruning or deletion. Their counts went with the posts.
+func (p *pruner) pruneEngagements(ctx context.Context) error {
+	var total int64
+	defer func() {
+		if total > 0 {
+			p.logger.Info("pruned engagements", "deleted", total)
+		}
+	}()
+	for {
+		deleted, err := p.store.PruneEngagements(ctx, p.batchSize)
+		if err != nil {
+			return err
+		}
+		total += deleted
+		if deleted < int64(p.batchSize) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pruneBatchPause):
+		}
+	}
+}
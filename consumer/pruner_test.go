@@ -0,0 +1,78 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"jetstream-feed-generator/store"
+)
+
+func TestPrunerEnforcesRetention(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	feeds := []FeedConfig{
+		{Name: "by-age", Retention: Retention{MaxAge: time.Hour}},
+		{Name: "by-count", Retention: Retention{MaxPosts: 2}},
+		{Name: "unlimited"},
+	}
+	now := time.Now()
+	ages := []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, 10 * time.Minute}
+	for _, fc := range feeds {
+		if err := st.UpsertFeed(ctx, fc.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := st.InTx(ctx, func(w store.Writer) error {
+		for _, fc := range feeds {
+			for i, age := range ages {
+				post := store.FeedPost{FeedName: fc.Name, TimeUs: now.Add(-age).UnixMicro(), Did: "did:plc:" + fc.Name, Rkey: string(rune('a' + i))}
+				if err := w.UpsertFeedPost(ctx, post); err != nil {
+					return err
+				}
+				like := store.Engagement{Did: "did:plc:liker", Collection: store.LikeCollection, Rkey: fc.Name + post.Rkey, SubjectDid: post.Did, SubjectRkey: post.Rkey}
+				if err := w.AddEngagement(ctx, like); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch size below the number of posts to prune takes several rounds.
+	p := pruner{logger: slog.Default(), store: st, feeds: feeds, batchSize: 1}
+	p.prune(ctx)
+
+	for _, tt := range []struct {
+		feed string
+		want []string
+	}{
+		{"by-age", []string{"d", "c"}},
+		{"by-count", []string{"d", "c"}},
+		{"unlimited", []string{"d", "c", "b", "a"}},
+	} {
+		posts, err := st.GetFeedPosts(ctx, store.GetFeedPostsParams{FeedName: tt.feed, Before: 1 << 62, Limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rkeys []string
+		for _, post := range posts {
+			rkeys = append(rkeys, post.Rkey)
+			if post.LikeCount != 1 {
+				t.Errorf("%s/%s has %d likes, want 1", post.Did, post.Rkey, post.LikeCount)
+			}
+		}
+		if !slices.Equal(rkeys, tt.want) {
+			t.Errorf("%s posts = %q, want %q", tt.feed, rkeys, tt.want)
+		}
+	}
+	// The likes of the four pruned posts went with them; the others stay.
+	if deleted, err := st.PruneEngagements(ctx, 100); err != nil || deleted != 0 {
+		t.Errorf("%d engagements left to prune, %v, want 0", deleted, err)
+	}
+}
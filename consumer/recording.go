@@ -0,0 +1,144 @@
+package consumer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	jetstreamClient "github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMagic starts every zstd frame; recordings are decompressed if they
+// start with it, whatever their name.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Recorder writes events to a file as JSON lines, zstd-compressed if the
+// file name ends in .zst.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	zw   *zstd.Encoder
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+// NewRecorder creates the file, or appends to it if it exists. Appending to a
+// compressed recording starts a new zstd frame, which readers handle
+// transparently.
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	r := &Recorder{file: file}
+	var w io.Writer = file
+	if strings.HasSuffix(path, ".zst") {
+		r.zw, err = zstd.NewWriter(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		w = r.zw
+	}
+	r.w = bufio.NewWriter(w)
+	r.enc = json.NewEncoder(r.w)
+	return r, nil
+}
+
+func (r *Recorder) Record(event *models.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(event); err != nil {
+		return fmt.Errorf("failed to record event: %w", err)
+	}
+	return nil
+}
+
+// Close flushes buffered events and closes the file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Flush()
+	if r.zw != nil {
+		if zerr := r.zw.Close(); err == nil {
+			err = zerr
+		}
+	}
+	if ferr := r.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// recordingScheduler records events before handing them to the underlying
+// scheduler.
+type recordingScheduler struct {
+	jetstreamClient.Scheduler
+	recorder *Recorder
+}
+
+func (s *recordingScheduler) AddWork(ctx context.Context, repo string, event *models.Event) error {
+	if ctx.Err() != nil {
+		// Read after the connection was abandoned, possibly after the
+		// recording was closed. Dropped like in trackingScheduler.
+		return nil
+	}
+	if err := s.recorder.Record(event); err != nil {
+		return err
+	}
+	return s.Scheduler.AddWork(ctx, repo, event)
+}
+
+// RecordingReader reads events written by a Recorder.
+type RecordingReader struct {
+	file *os.File
+	zr   *zstd.Decoder
+	dec  *json.Decoder
+}
+
+func OpenRecording(path string) (*RecordingReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	r := &RecordingReader{file: file}
+	br := bufio.NewReader(file)
+	var rd io.Reader = br
+	if magic, _ := br.Peek(len(zstdMagic)); bytes.Equal(magic, zstdMagic) {
+		r.zr, err = zstd.NewReader(br)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		rd = r.zr
+	}
+	r.dec = json.NewDecoder(rd)
+	return r, nil
+}
+
+// Next returns the next event, or io.EOF at the end of the recording.
+func (r *RecordingReader) Next() (*models.Event, error) {
+	var event models.Event
+	if err := r.dec.Decode(&event); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read event: %w", err)
+	}
+	return &event, nil
+}
+
+func (r *RecordingReader) Close() error {
+	if r.zr != nil {
+		r.zr.Close()
+	}
+	return r.file.Close()
+}
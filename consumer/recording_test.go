@@ -0,0 +1,82 @@
+package consumer_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/consumer"
+	"jetstream-feed-generator/jetstreamtest"
+	"jetstream-feed-generator/store"
+)
+
+func TestReplayRebuildsRecordedFeed(t *testing.T) {
+	for _, name := range []string{"events.jsonl", "events.jsonl.zst"} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), name)
+
+			// Record a live run.
+			srv := jetstreamtest.NewServer()
+			defer srv.Close()
+			st := openStore(t)
+			status := consumer.NewStatus()
+			config := testConfig(srv, st, status)
+			config.RecordFile = path
+			stop := runConsumerConfig(t, config)
+			waitFor(t, "connection", func() bool { return srv.Connected() == 1 })
+			deleted := postEvent(t, 0, "did:plc:author", "a", models.CommitOperationDelete, nil)
+			srv.AddEvents(examplePost(t, "a"), examplePost(t, "b"), examplePost(t, "c"), deleted)
+			waitForCheckpoint(t, status, deleted)
+			if err := stop(); err != nil {
+				t.Fatal(err)
+			}
+			want := pageURIs(t, st, exampleFeed)
+			if len(want) != 2 {
+				t.Fatalf("recorded feed = %q, want 2 posts", want)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed := bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd})
+			if wantCompressed := filepath.Ext(name) == ".zst"; compressed != wantCompressed {
+				t.Errorf("recording compressed = %v, want %v", compressed, wantCompressed)
+			}
+
+			// Replaying delivers every event whatever the feed's cursor, and
+			// leaves the cursor alone, so it can be done again.
+			replayed := openStore(t)
+			saved := time.Now().Add(time.Hour).UnixMicro()
+			if err := replayed.UpsertFeed(ctx, exampleFeed); err != nil {
+				t.Fatal(err)
+			}
+			if err := replayed.InTx(ctx, func(w store.Writer) error { return w.UpdateFeedCursor(ctx, exampleFeed, saved) }); err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				err := consumer.Replay(ctx, consumer.ReplayConfig{
+					File:      path,
+					BatchSize: 100,
+					Feeds:     config.Feeds,
+					Store:     replayed,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := pageURIs(t, replayed, exampleFeed); !slices.Equal(got, want) {
+					t.Errorf("replayed feed = %q, want %q", got, want)
+				}
+				if cursor, err := replayed.FeedCursor(ctx, exampleFeed); err != nil || cursor != saved {
+					t.Errorf("cursor after replay = %d, %v, want %d", cursor, err, saved)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"jetstream-feed-generator/store"
+)
+
+type ReplayConfig struct {
+	// File is a recording made with Config.RecordFile.
+	File string
+	// Realtime replays events at the pace they were recorded instead of as
+	// fast as possible.
+	Realtime  bool
+	BatchSize int
+	Feeds     []FeedConfig
+	Store     store.Store
+}
+
+// Replay runs a recording through the configured feeds. Every event is
+// delivered to every feed, whatever its saved cursor, and feed cursors are
+// left unchanged, so a recording can be replayed any number of times.
+// Events that fail are logged and counted, and the replay carries on.
+func Replay(ctx context.Context, config ReplayConfig) error {
+	logger := slog.With("component", "replay")
+	batch := NewBatchWriter(config.Store, logger, config.BatchSize)
+	handler, err := newHandler(ctx, config.Feeds, logger, config.Store, batch)
+	if err != nil {
+		return err
+	}
+	// Events aren't started, as nothing is checkpointed.
+	handler.tracker = newCursorTracker(0)
+
+	recording, err := OpenRecording(config.File)
+	if err != nil {
+		return err
+	}
+	defer recording.Close()
+
+	logger.Info("replaying recording", "file", config.File, "realtime", config.Realtime)
+	var events, failed int
+	var firstEventUS int64
+	start := time.Now()
+	for {
+		event, err := recording.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if config.Realtime {
+			if firstEventUS == 0 {
+				firstEventUS = event.TimeUS
+			}
+			offset := time.Duration(event.TimeUS-firstEventUS) * time.Microsecond
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := handler.HandleEvent(ctx, event); err != nil {
+			logger.Warn("failed to handle event", "did", event.Did, "time_us", event.TimeUS, "error", err)
+			failed++
+		}
+		events++
+
+		select {
+		case <-batch.Full():
+			if err := batch.Flush(ctx, nil); err != nil {
+				return fmt.Errorf("failed to flush batch: %w", err)
+			}
+		default:
+		}
+	}
+
+	if err := batch.Flush(ctx, nil); err != nil {
+		return fmt.Errorf("failed to flush batch: %w", err)
+	}
+	logger.Info("replay complete", "events", events, "failed", failed, "duration", time.Since(start))
+	return nil
+}
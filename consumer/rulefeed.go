@@ -0,0 +1,44 @@
+package consumer
+
+import (
+	"context"
+	"log/slog"
+
+	apibsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"jetstream-feed-generator/rules"
+	"jetstream-feed-generator/store"
+)
+
+// RuleFeed is a feed that stores every post matching a compiled rule.
+type RuleFeed struct {
+	feedStore
+	matcher rules.Matcher
+}
+
+func NewRuleFeed(name string, rule rules.Rule, logger *slog.Logger, st store.Store, batch *BatchWriter) (*RuleFeed, error) {
+	matcher, err := rule.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return &RuleFeed{
+		feedStore: newFeedStore(name, logger, st, batch),
+		matcher:   matcher,
+	}, nil
+}
+
+func (f *RuleFeed) HandlePost(ctx context.Context, event *models.Event, post *apibsky.FeedPost) error {
+	if f.matcher.Match(event.Did, post) {
+		f.logger.Debug("post matched", "did", event.Did, "rkey", event.Commit.RKey, "text", post.Text)
+		return f.addPost(ctx, event)
+	}
+	return nil
+}
+
+func (f *RuleFeed) HandleUpdate(ctx context.Context, event *models.Event, post *apibsky.FeedPost) error {
+	if f.matcher.Match(event.Did, post) {
+		f.logger.Debug("post matched", "did", event.Did, "rkey", event.Commit.RKey, "text", post.Text)
+		return f.addPost(ctx, event)
+	}
+	return f.HandleDelete(ctx, event)
+}
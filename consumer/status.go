@@ -0,0 +1,51 @@
+package consumer
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// Status exposes the consumer's connection state and checkpointed cursors to
+// health checks in other goroutines.
+type Status struct {
+	connected atomic.Bool
+
+	mu          sync.RWMutex
+	cursor      int64
+	feedCursors map[string]int64
+}
+
+func NewStatus() *Status {
+	return &Status{feedCursors: make(map[string]int64)}
+}
+
+// Connected reports whether the consumer is receiving events from Jetstream.
+func (s *Status) Connected() bool {
+	return s.connected.Load()
+}
+
+// Cursor returns the latest checkpointed cursor of the shared event stream.
+func (s *Status) Cursor() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cursor
+}
+
+// FeedCursors returns the latest checkpointed cursor of each feed.
+func (s *Status) FeedCursors() map[string]int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.feedCursors)
+}
+
+func (s *Status) setConnected(connected bool) {
+	s.connected.Store(connected)
+}
+
+func (s *Status) setCursors(cursor int64, feedCursors map[string]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cursor = cursor
+	s.feedCursors = feedCursors
+}
@@ -0,0 +1,89 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	jetstreamClient "github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+// stream is one subscription to a Jetstream instance. It reads events the
+// way the Jetstream client does, but closes its connection as soon as its
+// context is done; the client only checks between messages, so it never
+// returns from a connection that has gone quiet.
+type stream struct {
+	config     jetstreamClient.ClientConfig
+	scheduler  jetstreamClient.Scheduler
+	eventsRead atomic.Int64
+	bytesRead  atomic.Int64
+}
+
+// run subscribes from cursor and hands each event to the scheduler until the
+// connection fails or ctx is done. It returns once the connection is closed
+// and the last event read has been handed on.
+func (s *stream) run(ctx context.Context, cursor int64) error {
+	u, err := url.Parse(s.config.WebsocketURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse Jetstream URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("cursor", strconv.FormatInt(cursor, 10))
+	for _, did := range s.config.WantedDids {
+		query.Add("wantedDids", did)
+	}
+	for _, collection := range s.config.WantedCollections {
+		query.Add("wantedCollections", collection)
+	}
+	u.RawQuery = query.Encode()
+
+	header := http.Header{}
+	for k, v := range s.config.ExtraHeaders {
+		header.Set(k, v)
+	}
+	var decoder *zstd.Decoder
+	if s.config.Compress {
+		header.Set("Socket-Encoding", "zstd")
+		decoder, err = zstd.NewReader(nil, zstd.WithDecoderDicts(models.ZSTDDictionary))
+		if err != nil {
+			return fmt.Errorf("failed to create zstd decoder: %w", err)
+		}
+		defer decoder.Close()
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("failed to read message: %w", err)
+		}
+		s.bytesRead.Add(int64(len(msg)))
+		s.eventsRead.Add(1)
+		if decoder != nil {
+			if msg, err = decoder.DecodeAll(msg, nil); err != nil {
+				return fmt.Errorf("failed to decompress message: %w", err)
+			}
+		}
+		var event models.Event
+		if err := json.Unmarshal(msg, &event); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		if err := s.scheduler.AddWork(ctx, event.Did, &event); err != nil {
+			return fmt.Errorf("failed to add work to scheduler: %w", err)
+		}
+	}
+}
@@ -1,8 +1,207 @@
 package db
 
 import (
-	_ "embed"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-//go:embed migrations/schema.sql
-var SchemaSQL string
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// Migration is one numbered schema migration, loaded from a file named like
+// 0002_add_index.sql.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Dialect holds the differences between databases that the migrator cares
+// about.
+type Dialect struct {
+	migrations fs.FS
+	dir        string
+	// placeholders returns the bind parameters for a statement with n
+	// arguments.
+	placeholders func(n int) []string
+	// lockSQL, if set, runs at the start of each migration transaction so
+	// that concurrent processes apply migrations one at a time.
+	lockSQL string
+}
+
+var (
+	SQLite = Dialect{
+		migrations: sqliteMigrations,
+		dir:        "migrations/sqlite",
+		placeholders: func(n int) []string {
+			return strings.Split(strings.Repeat("?", n), "")
+		},
+	}
+	Postgres = Dialect{
+		migrations: postgresMigrations,
+		dir:        "migrations/postgres",
+		placeholders: func(n int) []string {
+			p := make([]string, n)
+			for i := range p {
+				p[i] = "$" + strconv.Itoa(i+1)
+			}
+			return p
+		},
+		lockSQL: "select pg_advisory_xact_lock(hashtext('schema_version'))",
+	}
+)
+
+const createSchemaVersion = `create table if not exists schema_version
+(
+    version    integer primary key,
+    name       text   not null,
+    applied_at bigint not null
+)`
+
+// Migrator applies a dialect's embedded migrations in order, recording each
+// applied version in the schema_version table.
+type Migrator struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func NewMigrator(db *sql.DB, dialect Dialect) *Migrator {
+	return &Migrator{db: db, dialect: dialect}
+}
+
+// Migrations returns every migration for the dialect, ordered by version.
+func (m *Migrator) Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(m.dialect.migrations, m.dialect.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+	migrations := make([]Migration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		versionStr, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s is not named like 0001_name.sql", entry.Name())
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, entry.Name())
+		}
+		seen[version] = entry.Name()
+		contents, err := fs.ReadFile(m.dialect.migrations, path.Join(m.dialect.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(contents)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Pending returns the migrations that haven't been applied yet.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	if _, err := m.db.ExecContext(ctx, createSchemaVersion); err != nil {
+		return nil, fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Apply runs each pending migration in its own transaction and returns the
+// ones that were applied.
+func (m *Migrator) Apply(ctx context.Context) ([]Migration, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, migration := range pending {
+		ran, err := m.apply(ctx, migration)
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		if ran {
+			applied = append(applied, migration)
+		}
+	}
+	return applied, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, migration Migration) (bool, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if m.dialect.lockSQL != "" {
+		if _, err := tx.ExecContext(ctx, m.dialect.lockSQL); err != nil {
+			return false, fmt.Errorf("failed to lock schema_version: %w", err)
+		}
+	}
+	// Another process may have applied it while we waited for the lock.
+	p := m.dialect.placeholders(3)
+	var exists int
+	err = tx.QueryRowContext(ctx,
+		"select count(*) from schema_version where version = "+p[0], migration.Version,
+	).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"insert into schema_version (version, name, applied_at) values ("+strings.Join(p, ", ")+")",
+		migration.Version, migration.Name, time.Now().Unix(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to record migration: %w", err)
+	}
+	return true, tx.Commit()
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int]bool, error) {
+	rows, err := m.db.QueryContext(ctx, "select version from schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_version: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,257 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	_ "modernc.org/sqlite"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	sqlDB, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return sqlDB
+}
+
+// testDialect is SQLite with the given migration files.
+func testDialect(files map[string]string) Dialect {
+	fsys := fstest.MapFS{}
+	for name, contents := range files {
+		fsys["migrations/"+name] = &fstest.MapFile{Data: []byte(contents)}
+	}
+	d := SQLite
+	d.migrations = fsys
+	d.dir = "migrations"
+	return d
+}
+
+func versions(migrations []Migration) []int {
+	var vs []int
+	for _, m := range migrations {
+		vs = append(vs, m.Version)
+	}
+	return vs
+}
+
+func TestMigrationsAreOrderedByVersion(t *testing.T) {
+	m := NewMigrator(nil, testDialect(map[string]string{
+		"0010_last.sql":   "",
+		"0002_second.sql": "",
+		"0001_first.sql":  "",
+	}))
+	migrations, err := m.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(migrations); !slices.Equal(got, []int{1, 2, 10}) {
+		t.Errorf("versions = %v, want [1 2 10]", got)
+	}
+	if migrations[0].Name != "first" {
+		t.Errorf("name = %q, want first", migrations[0].Name)
+	}
+}
+
+func TestMigrationsRejectsBadFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"no version", map[string]string{"initial.sql": ""}, "not named like"},
+		{"no name", map[string]string{"0001.sql": ""}, "not named like"},
+		{"zero version", map[string]string{"0000_initial.sql": ""}, "not named like"},
+		{"duplicate version", map[string]string{"0001_a.sql": "", "0001_b.sql": ""}, "same version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMigrator(nil, testDialect(tt.files)).Migrations()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRunsPendingMigrationsOnce(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := openDB(t)
+	files := map[string]string{
+		"0001_create.sql": "create table t (a integer);",
+		"0002_column.sql": "alter table t add column b integer;",
+	}
+	m := NewMigrator(sqlDB, testDialect(files))
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(pending); !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("pending = %v, want [1 2]", got)
+	}
+	applied, err := m.Apply(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(applied); !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("applied = %v, want [1 2]", got)
+	}
+	if _, err := sqlDB.ExecContext(ctx, "insert into t (a, b) values (1, 2)"); err != nil {
+		t.Fatalf("schema not migrated: %v", err)
+	}
+
+	applied, err = m.Apply(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("applied %v again", versions(applied))
+	}
+
+	// A release adding a migration only applies the new one.
+	files["0003_index.sql"] = "create index t_a on t (a);"
+	m = NewMigrator(sqlDB, testDialect(files))
+	pending, err = m.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(pending); !slices.Equal(got, []int{3}) {
+		t.Fatalf("pending = %v, want [3]", got)
+	}
+	applied, err = m.Apply(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(applied); !slices.Equal(got, []int{3}) {
+		t.Errorf("applied = %v, want [3]", got)
+	}
+}
+
+func TestApplyStopsAtFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := openDB(t)
+	m := NewMigrator(sqlDB, testDialect(map[string]string{
+		"0001_create.sql": "create table t (a integer);",
+		"0002_broken.sql": "create table u (a integer); alter table missing add column b integer;",
+		"0003_later.sql":  "create table v (a integer);",
+	}))
+
+	applied, err := m.Apply(ctx)
+	if err == nil || !strings.Contains(err.Error(), "0002_broken") {
+		t.Fatalf("error = %v, want one naming 0002_broken", err)
+	}
+	if got := versions(applied); !slices.Equal(got, []int{1}) {
+		t.Errorf("applied = %v, want [1]", got)
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(pending); !slices.Equal(got, []int{2, 3}) {
+		t.Errorf("pending = %v, want [2 3]", got)
+	}
+	// The failed migration was rolled back as a whole.
+	if _, err := sqlDB.ExecContext(ctx, "select * from u"); err == nil {
+		t.Error("table u exists after its migration failed")
+	}
+}
+
+func TestEmbeddedSQLiteMigrationsApply(t *testing.T) {
+	ctx := context.Background()
+	m := NewMigrator(openDB(t), SQLite)
+	all, err := m.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := m.Apply(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(all) {
+		t.Errorf("applied %d of %d migrations", len(applied), len(all))
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("pending after applying all = %v", versions(pending))
+	}
+}
+
+// embeddedSQLite returns the embedded SQLite migrations up to version.
+func embeddedSQLite(t *testing.T, version int) Dialect {
+	t.Helper()
+	all, err := NewMigrator(nil, SQLite).Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, m := range all {
+		if m.Version <= version {
+			files[fmt.Sprintf("%04d_%s.sql", m.Version, m.Name)] = m.SQL
+		}
+	}
+	return testDialect(files)
+}
+
+func TestCompactGraphMigrationMovesEdges(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := openDB(t)
+	if _, err := NewMigrator(sqlDB, embeddedSQLite(t, 4)).Apply(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, err := sqlDB.ExecContext(ctx, `insert into graph_edges (did, collection, rkey, subject_did)
+values ('did:plc:a', 'app.bsky.graph.follow', '1', 'did:plc:b'),
+       ('did:plc:a', 'app.bsky.graph.block', '2', 'did:plc:c'),
+       ('did:plc:b', 'app.bsky.graph.follow', '3', 'did:plc:a')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := NewMigrator(sqlDB, SQLite).Apply(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(applied); !slices.Contains(got, 5) {
+		t.Fatalf("applied = %v, want 5 among them", got)
+	}
+	rows, err := sqlDB.QueryContext(ctx, `select actor.did, graph_edges.kind, graph_edges.rkey, subject.did
+from graph_edges
+         join actors actor on actor.id = graph_edges.actor_id
+         join actors subject on subject.id = graph_edges.subject_id
+order by graph_edges.rkey`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var did, rkey, subject string
+		var kind int
+		if err := rows.Scan(&did, &kind, &rkey, &subject); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %d %s %s", did, kind, rkey, subject))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"did:plc:a 1 1 did:plc:b",
+		"did:plc:a 2 2 did:plc:c",
+		"did:plc:b 1 3 did:plc:a",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("edges = %q, want %q", got, want)
+	}
+}
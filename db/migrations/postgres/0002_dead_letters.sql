@@ -0,0 +1,10 @@
+create table dead_letters
+(
+    id        bigserial primary key,
+    time_us   bigint not null,
+    did       text   not null,
+    event     text   not null,
+    error     text   not null,
+    attempts  bigint not null,
+    failed_at bigint not null
+);
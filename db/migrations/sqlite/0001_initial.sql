@@ -1,3 +1,6 @@
+-- Tables are created only if missing, so databases set up before versioned
+-- migrations existed adopt this as their first version.
+
 create table if not exists feeds
 (
     feed_name     text primary key,
@@ -14,3 +17,11 @@ create table if not exists feed_posts
 
 create unique index if not exists feed_posts_unique_by_feed on feed_posts (feed_name, did, rkey);
 create index if not exists feed_posts_by_time on feed_posts (feed_name, time_us);
+
+create table if not exists accounts
+(
+    did     text primary key,
+    active  boolean not null,
+    status  text,
+    time_us integer not null
+);
@@ -0,0 +1,10 @@
+create table dead_letters
+(
+    id        integer primary key,
+    time_us   integer not null,
+    did       text    not null,
+    event     text    not null,
+    error     text    not null,
+    attempts  integer not null,
+    failed_at integer not null
+);
@@ -0,0 +1,21 @@
+-- Like and repost counts are kept on feed_posts, for posts in some feed.
+alter table feed_posts add column like_count integer not null default 0;
+alter table feed_posts add column repost_count integer not null default 0;
+-- The most likes plus reposts the post has had. Rankings use it rather than
+-- the current counts because it never goes down, so scores that only rise
+-- can be paged through without repeating posts.
+alter table feed_posts add column peak_engagement integer not null default 0;
+
+create index feed_posts_by_post on feed_posts (did, rkey);
+create index feed_posts_by_engagement on feed_posts (feed_name, peak_engagement, time_us);
+
+-- Likes and reposts of posts in some feed, so deletes can be counted.
+create table engagements
+(
+    did          text not null,
+    collection   text not null,
+    rkey         text not null,
+    subject_did  text not null,
+    subject_rkey text not null,
+    primary key (did, collection, rkey)
+);
@@ -0,0 +1,11 @@
+-- Follow and block records, for personalizing feeds.
+create table graph_edges
+(
+    did         text not null,
+    collection  text not null,
+    rkey        text not null,
+    subject_did text not null,
+    primary key (did, collection, rkey)
+);
+
+create index graph_edges_by_subject on graph_edges (did, collection, subject_did);
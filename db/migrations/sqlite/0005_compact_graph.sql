@@ -0,0 +1,38 @@
+-- Store follows and blocks compactly: each DID once in actors, and edges as
+-- pairs of actor IDs with a kind (1 for follows, 2 for blocks) in place of
+-- the collection.
+create table actors
+(
+    id  integer primary key,
+    did text not null unique
+);
+
+insert into actors (did)
+select did
+from graph_edges
+union
+select subject_did
+from graph_edges;
+
+create table graph_edges_compact
+(
+    actor_id   integer not null,
+    kind       integer not null,
+    rkey       text    not null,
+    subject_id integer not null,
+    primary key (actor_id, kind, rkey)
+) without rowid;
+
+insert into graph_edges_compact (actor_id, kind, rkey, subject_id)
+select actor.id,
+       case graph_edges.collection when 'app.bsky.graph.follow' then 1 else 2 end,
+       graph_edges.rkey,
+       subject.id
+from graph_edges
+         join actors actor on actor.did = graph_edges.did
+         join actors subject on subject.did = graph_edges.subject_did;
+
+drop table graph_edges;
+alter table graph_edges_compact rename to graph_edges;
+
+create index graph_edges_by_subject on graph_edges (subject_id, kind, actor_id);
@@ -0,0 +1,288 @@
+-- name: UpsertFeed :exec
+insert into feeds (feed_name)
+values ($1)
+on conflict do nothing;
+
+-- name: GetFeed :one
+select *
+from feeds
+where feed_name = $1;
+
+-- name: UpdateFeedCursor :exec
+update feeds
+set latest_cursor = $1
+where feed_name = $2;
+
+-- name: UpsertFeedPost :exec
+insert
+into feed_posts (feed_name, time_us, did, rkey)
+values ($1, $2, $3, $4)
+on conflict (feed_name, did, rkey) do update set time_us = excluded.time_us;
+
+-- name: DeleteFeedPost :exec
+delete
+from feed_posts
+where feed_name = $1
+  and did = $2
+  and rkey = $3;
+
+-- name: GetFeedPosts :many
+select *
+from feed_posts
+where feed_name = sqlc.arg(feed_name)
+  and (time_us, did, rkey) < (sqlc.arg(before), sqlc.arg(before_did), sqlc.arg(before_rkey))
+  and time_us > sqlc.arg(after)
+  and not exists (select 1
+                  from accounts
+                  where accounts.did = feed_posts.did
+                    and accounts.active = false)
+  and (not sqlc.arg(hide_blocked)
+    or not exists (select 1
+                   from graph_edges
+                            join actors viewer on viewer.did = sqlc.arg(viewer)
+                            join actors author on author.did = feed_posts.did
+                   where graph_edges.kind = 2
+                     and ((graph_edges.actor_id = viewer.id and graph_edges.subject_id = author.id)
+                       or (graph_edges.actor_id = author.id and graph_edges.subject_id = viewer.id))))
+order by time_us desc, did desc, rkey desc
+limit sqlc.arg('limit');
+
+-- name: GetFollowingFeedPosts :many
+select *
+from feed_posts
+where feed_name = sqlc.arg(feed_name)
+  and did in (select author.did
+              from graph_edges
+                       join actors viewer on viewer.id = graph_edges.actor_id
+                       join actors author on author.id = graph_edges.subject_id
+              where viewer.did = sqlc.arg(viewer)
+                and graph_edges.kind = 1)
+  and (time_us, did, rkey) < (sqlc.arg(before), sqlc.arg(before_did), sqlc.arg(before_rkey))
+  and time_us > sqlc.arg(after)
+  and not exists (select 1
+                  from accounts
+                  where accounts.did = feed_posts.did
+                    and accounts.active = false)
+  and (not sqlc.arg(hide_blocked)
+    or not exists (select 1
+                   from graph_edges
+                            join actors viewer on viewer.did = sqlc.arg(viewer)
+                            join actors author on author.did = feed_posts.did
+                   where graph_edges.kind = 2
+                     and ((graph_edges.actor_id = viewer.id and graph_edges.subject_id = author.id)
+                       or (graph_edges.actor_id = author.id and graph_edges.subject_id = viewer.id))))
+order by time_us desc, did desc, rkey desc
+limit sqlc.arg('limit');
+
+-- name: UpsertAccount :exec
+insert
+into accounts (did, active, status, time_us)
+values ($1, $2, $3, $4)
+on conflict (did) do update set active  = excluded.active,
+                                status  = excluded.status,
+                                time_us = excluded.time_us
+where excluded.time_us >= accounts.time_us;
+
+-- name: DeleteAccountPosts :exec
+delete
+from feed_posts
+where did = $1;
+
+-- name: GetFeedPostCutoff :one
+select time_us
+from feed_posts
+where feed_name = $1
+order by time_us desc
+limit 1 offset $2;
+
+-- name: PruneFeedPosts :execrows
+delete
+from feed_posts
+where (feed_name, did, rkey) in (select feed_name, did, rkey
+                                 from feed_posts
+                                 where feed_name = $1
+                                   and time_us < $2
+                                 limit $3);
+
+-- name: ListFeeds :many
+select feeds.feed_name,
+       feeds.latest_cursor,
+       (select count(*)
+        from feed_posts
+        where feed_posts.feed_name = feeds.feed_name) as post_count
+from feeds
+order by feeds.feed_name;
+
+-- name: InsertDeadLetter :exec
+insert
+into dead_letters (time_us, did, event, error, attempts, failed_at)
+values ($1, $2, $3, $4, $5, $6);
+
+-- name: ListDeadLetters :many
+select *
+from dead_letters
+where id > $1
+order by id
+limit $2;
+
+-- name: DeleteDeadLetter :exec
+delete
+from dead_letters
+where id = $1;
+
+-- name: UpdateDeadLetterFailure :exec
+update dead_letters
+set error     = $1,
+    attempts  = attempts + 1,
+    failed_at = $2
+where id = $3;
+
+-- name: CountPostFeeds :one
+select count(*)
+from feed_posts
+where did = $1
+  and rkey = $2;
+
+-- name: InsertEngagement :execrows
+insert
+into engagements (did, collection, rkey, subject_did, subject_rkey)
+values ($1, $2, $3, $4, $5)
+on conflict do nothing;
+
+-- name: DeleteEngagement :one
+delete
+from engagements
+where did = $1
+  and collection = $2
+  and rkey = $3
+returning subject_did, subject_rkey;
+
+-- name: UpdatePostEngagement :exec
+update feed_posts
+set like_count      = like_count + sqlc.arg(like_delta)::bigint,
+    repost_count    = repost_count + sqlc.arg(repost_delta)::bigint,
+    peak_engagement = greatest(peak_engagement,
+                               like_count + repost_count + sqlc.arg(like_delta)::bigint +
+                               sqlc.arg(repost_delta)::bigint)
+where did = sqlc.arg(did)
+  and rkey = sqlc.arg(rkey);
+
+-- name: PruneEngagements :execrows
+delete
+from engagements
+where (did, collection, rkey) in (select did, collection, rkey
+                                  from engagements
+                                  where not exists (select 1
+                                                    from feed_posts
+                                                    where feed_posts.did = engagements.subject_did
+                                                      and feed_posts.rkey = engagements.subject_rkey)
+                                  limit $1);
+
+-- name: GetTopFeedPosts :many
+select *
+from feed_posts
+where feed_name = sqlc.arg(feed_name)
+  and time_us <= sqlc.arg(as_of)
+  and time_us > sqlc.arg(after)
+  and not exists (select 1
+                  from accounts
+                  where accounts.did = feed_posts.did
+                    and accounts.active = false)
+  and (not sqlc.arg(hide_blocked)
+    or not exists (select 1
+                   from graph_edges
+                            join actors viewer on viewer.did = sqlc.arg(viewer)
+                            join actors author on author.did = feed_posts.did
+                   where graph_edges.kind = 2
+                     and ((graph_edges.actor_id = viewer.id and graph_edges.subject_id = author.id)
+                       or (graph_edges.actor_id = author.id and graph_edges.subject_id = viewer.id))))
+order by peak_engagement desc, time_us desc
+limit sqlc.arg('limit');
+
+-- name: GetFollowingTopFeedPosts :many
+select *
+from feed_posts
+where feed_name = sqlc.arg(feed_name)
+  and did in (select author.did
+              from graph_edges
+                       join actors viewer on viewer.id = graph_edges.actor_id
+                       join actors author on author.id = graph_edges.subject_id
+              where viewer.did = sqlc.arg(viewer)
+                and graph_edges.kind = 1)
+  and time_us <= sqlc.arg(as_of)
+  and time_us > sqlc.arg(after)
+  and not exists (select 1
+                  from accounts
+                  where accounts.did = feed_posts.did
+                    and accounts.active = false)
+  and (not sqlc.arg(hide_blocked)
+    or not exists (select 1
+                   from graph_edges
+                            join actors viewer on viewer.did = sqlc.arg(viewer)
+                            join actors author on author.did = feed_posts.did
+                   where graph_edges.kind = 2
+                     and ((graph_edges.actor_id = viewer.id and graph_edges.subject_id = author.id)
+                       or (graph_edges.actor_id = author.id and graph_edges.subject_id = viewer.id))))
+order by peak_engagement desc, time_us desc
+limit sqlc.arg('limit');
+
+-- name: InsertActor :exec
+insert
+into actors (did)
+values ($1)
+on conflict do nothing;
+
+-- name: InsertGraphEdge :exec
+insert
+into graph_edges (actor_id, kind, rkey, subject_id)
+select actor.id, sqlc.arg(kind)::smallint, sqlc.arg(rkey)::text, subject.id
+from actors actor,
+     actors subject
+where actor.did = sqlc.arg(did)
+  and subject.did = sqlc.arg(subject_did)
+on conflict do nothing;
+
+-- name: DeleteGraphEdge :exec
+delete
+from graph_edges
+where actor_id = (select id from actors where did = $1)
+  and kind = $2
+  and rkey = $3;
+
+-- name: DeleteActorGraphEdges :exec
+delete
+from graph_edges
+where actor_id = (select id from actors where did = $1);
+
+-- name: ListGraphSubjects :many
+select subject.did
+from graph_edges
+         join actors actor on actor.id = graph_edges.actor_id
+         join actors subject on subject.id = graph_edges.subject_id
+where actor.did = sqlc.arg(did)
+  and graph_edges.kind = sqlc.arg(kind)
+  and graph_edges.subject_id > coalesce((select id from actors where did = sqlc.arg(after)), 0)
+group by graph_edges.subject_id, subject.did
+order by graph_edges.subject_id
+limit sqlc.arg('limit');
+
+-- name: ListGraphActors :many
+select actor.did
+from graph_edges
+         join actors actor on actor.id = graph_edges.actor_id
+         join actors subject on subject.id = graph_edges.subject_id
+where subject.did = sqlc.arg(subject_did)
+  and graph_edges.kind = sqlc.arg(kind)
+  and graph_edges.actor_id > coalesce((select id from actors where did = sqlc.arg(after)), 0)
+group by graph_edges.actor_id, actor.did
+order by graph_edges.actor_id
+limit sqlc.arg('limit');
+
+-- name: ListGraphEdgesBetween :many
+select actor.did as did, graph_edges.kind, graph_edges.rkey, subject.did as subject_did
+from graph_edges
+         join actors actor on actor.id = graph_edges.actor_id
+         join actors subject on subject.id = graph_edges.subject_id
+where (actor.did = sqlc.arg(did) and subject.did = sqlc.arg(other_did))
+   or (actor.did = sqlc.arg(other_did) and subject.did = sqlc.arg(did))
+order by graph_edges.kind, actor.did, graph_edges.rkey;
@@ -8,14 +8,54 @@ import (
 	"database/sql"
 )
 
+type Account struct {
+	Did    string
+	Active bool
+	Status sql.NullString
+	TimeUs int64
+}
+
+type Actor struct {
+	ID  int64
+	Did string
+}
+
+type DeadLetter struct {
+	ID       int64
+	TimeUs   int64
+	Did      string
+	Event    string
+	Error    string
+	Attempts int64
+	FailedAt int64
+}
+
+type Engagement struct {
+	Did         string
+	Collection  string
+	Rkey        string
+	SubjectDid  string
+	SubjectRkey string
+}
+
 type Feed struct {
 	FeedName     string
 	LatestCursor sql.NullInt64
 }
 
 type FeedPost struct {
-	FeedName string
-	TimeUs   int64
-	Did      string
-	Rkey     string
+	FeedName       string
+	TimeUs         int64
+	Did            string
+	Rkey           string
+	LikeCount      int64
+	RepostCount    int64
+	PeakEngagement int64
+}
+
+type GraphEdge struct {
+	ActorID   int64
+	Kind      int64
+	Rkey      string
+	SubjectID int64
 }
@@ -10,6 +10,123 @@ import (
 	"database/sql"
 )
 
+const countPostFeeds = `-- name: CountPostFeeds :one
+select count(*)
+from feed_posts
+where did = ?
+  and rkey = ?
+`
+
+type CountPostFeedsParams struct {
+	Did  string
+	Rkey string
+}
+
+func (q *Queries) CountPostFeeds(ctx context.Context, arg CountPostFeedsParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countPostFeeds, arg.Did, arg.Rkey)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteAccountPosts = `-- name: DeleteAccountPosts :exec
+delete
+from feed_posts
+where did = ?
+`
+
+func (q *Queries) DeleteAccountPosts(ctx context.Context, did string) error {
+	_, err := q.db.ExecContext(ctx, deleteAccountPosts, did)
+	return err
+}
+
+const deleteActorGraphEdges = `-- name: DeleteActorGraphEdges :exec
+delete
+from graph_edges
+where actor_id = (select id from actors where did = ?)
+`
+
+func (q *Queries) DeleteActorGraphEdges(ctx context.Context, did string) error {
+	_, err := q.db.ExecContext(ctx, deleteActorGraphEdges, did)
+	return err
+}
+
+const deleteDeadLetter = `-- name: DeleteDeadLetter :exec
+delete
+from dead_letters
+where id = ?
+`
+
+func (q *Queries) DeleteDeadLetter(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteDeadLetter, id)
+	return err
+}
+
+const deleteEngagement = `-- name: DeleteEngagement :one
+delete
+from engagements
+where did = ?
+  and collection = ?
+  and rkey = ?
+returning subject_did, subject_rkey
+`
+
+type DeleteEngagementParams struct {
+	Did        string
+	Collection string
+	Rkey       string
+}
+
+type DeleteEngagementRow struct {
+	SubjectDid  string
+	SubjectRkey string
+}
+
+func (q *Queries) DeleteEngagement(ctx context.Context, arg DeleteEngagementParams) (DeleteEngagementRow, error) {
+	row := q.db.QueryRowContext(ctx, deleteEngagement, arg.Did, arg.Collection, arg.Rkey)
+	var i DeleteEngagementRow
+	err := row.Scan(&i.SubjectDid, &i.SubjectRkey)
+	return i, err
+}
+
+const deleteFeedPost = `-- name: DeleteFeedPost :exec
+delete
+from feed_posts
+where feed_name = ?
+  and did = ?
+  and rkey = ?
+`
+
+type DeleteFeedPostParams struct {
+	FeedName string
+	Did      string
+	Rkey     string
+}
+
+func (q *Queries) DeleteFeedPost(ctx context.Context, arg DeleteFeedPostParams) error {
+	_, err := q.db.ExecContext(ctx, deleteFeedPost, arg.FeedName, arg.Did, arg.Rkey)
+	return err
+}
+
+const deleteGraphEdge = `-- name: DeleteGraphEdge :exec
+delete
+from graph_edges
+where actor_id = (select id from actors where did = ?)
+  and kind = ?
+  and rkey = ?
+`
+
+type DeleteGraphEdgeParams struct {
+	Did  string
+	Kind int64
+	Rkey string
+}
+
+func (q *Queries) DeleteGraphEdge(ctx context.Context, arg DeleteGraphEdgeParams) error {
+	_, err := q.db.ExecContext(ctx, deleteGraphEdge, arg.Did, arg.Kind, arg.Rkey)
+	return err
+}
+
 const getFeed = `-- name: GetFeed :one
 select feed_name, latest_cursor
 from feeds
@@ -23,23 +140,230 @@ func (q *Queries) GetFeed(ctx context.Context, feedName string) (Feed, error) {
 	return i, err
 }
 
-const getFeedPosts = `-- name: GetFeedPosts :many
-select feed_name, time_us, did, rkey
+const getFeedPostCutoff = `-- name: GetFeedPostCutoff :one
+select time_us
 from feed_posts
 where feed_name = ?
-  and time_us < ?
 order by time_us desc
+limit 1 offset ?
+`
+
+type GetFeedPostCutoffParams struct {
+	FeedName string
+	Offset   int64
+}
+
+func (q *Queries) GetFeedPostCutoff(ctx context.Context, arg GetFeedPostCutoffParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getFeedPostCutoff, arg.FeedName, arg.Offset)
+	var time_us int64
+	err := row.Scan(&time_us)
+	return time_us, err
+}
+
+const getFeedPosts = `-- name: GetFeedPosts :many
+select feed_name, time_us, did, rkey, like_count, repost_count, peak_engagement
+from feed_posts
+where feed_name = ?
+  and (time_us, did, rkey) < (?, ?, ?)
+  and time_us > ?
+  and not exists (select 1
+                  from accounts
+                  where accounts.did = feed_posts.did
+                    and accounts.active = false)
+  and (not ?
+    or not exists (select 1
+                   from graph_edges
+                            join actors viewer on viewer.did = ?
+                            join actors author on author.did = feed_posts.did
+                   where graph_edges.kind = 2
+                     and ((graph_edges.actor_id = viewer.id and graph_edges.subject_id = author.id)
+                       or (graph_edges.actor_id = author.id and graph_edges.subject_id = viewer.id))))
+order by time_us desc, did desc, rkey desc
 limit ?
 `
 
 type GetFeedPostsParams struct {
-	FeedName string
-	TimeUs   int64
-	Limit    int64
+	FeedName    string
+	Before      int64
+	BeforeDid   string
+	BeforeRkey  string
+	After       int64
+	HideBlocked bool
+	Viewer      string
+	Limit       int64
 }
 
 func (q *Queries) GetFeedPosts(ctx context.Context, arg GetFeedPostsParams) ([]FeedPost, error) {
-	rows, err := q.db.QueryContext(ctx, getFeedPosts, arg.FeedName, arg.TimeUs, arg.Limit)
+	rows, err := q.db.QueryContext(ctx, getFeedPosts,
+		arg.FeedName,
+		arg.Before,
+		arg.BeforeDid,
+		arg.BeforeRkey,
+		arg.After,
+		arg.HideBlocked,
+		arg.Viewer,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeedPost
+	for rows.Next() {
+		var i FeedPost
+		if err := rows.Scan(
+			&i.FeedName,
+			&i.TimeUs,
+			&i.Did,
+			&i.Rkey,
+			&i.LikeCount,
+			&i.RepostCount,
+			&i.PeakEngagement,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFollowingFeedPosts = `-- name: GetFollowingFeedPosts :many
+select feed_name, time_us, did, rkey, like_count, repost_count, peak_engagement
+from feed_posts
+where feed_name = ?
+  and did in (select author.did
+              from graph_edges
+                       join actors viewer on viewer.id = graph_edges.actor_id
+                       join actors author on author.id = graph_edges.subject_id
+              where viewer.did = ?
+                and graph_edges.kind = 1)
+  and (+time_us, did, rkey) < (?, ?, ?)
+  and +time_us > ?
+  and not exists (select 1
+                  from accounts
+                  where accounts.did = feed_posts.did
+                    and accounts.active = false)
+  and (not ?
+    or not exists (select 1
+                   from graph_edges
+                            join actors viewer on viewer.did = ?
+                            join actors author on author.did = feed_posts.did
+                   where graph_edges.kind = 2
+                     and ((graph_edges.actor_id = viewer.id and graph_edges.subject_id = author.id)
+                       or (graph_edges.actor_id = author.id and graph_edges.subject_id = viewer.id))))
+order by time_us desc, did desc, rkey desc
+limit ?
+`
+
+type GetFollowingFeedPostsParams struct {
+	FeedName    string
+	Viewer      string
+	Before      int64
+	BeforeDid   string
+	BeforeRkey  string
+	After       int64
+	HideBlocked bool
+	Limit       int64
+}
+
+// The unary plus keeps SQLite from walking the feed in time order, so the
+// query is driven by the viewer's follows rather than the size of the feed.
+func (q *Queries) GetFollowingFeedPosts(ctx context.Context, arg GetFollowingFeedPostsParams) ([]FeedPost, error) {
+	rows, err := q.db.QueryContext(ctx, getFollowingFeedPosts,
+		arg.FeedName,
+		arg.Viewer,
+		arg.Before,
+		arg.BeforeDid,
+		arg.BeforeRkey,
+		arg.After,
+		arg.HideBlocked,
+		arg.Viewer,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeedPost
+	for rows.Next() {
+		var i FeedPost
+		if err := rows.Scan(
+			&i.FeedName,
+			&i.TimeUs,
+			&i.Did,
+			&i.Rkey,
+			&i.LikeCount,
+			&i.RepostCount,
+			&i.PeakEngagement,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFollowingTopFeedPosts = `-- name: GetFollowingTopFeedPosts :many
+select feed_name, time_us, did, rkey, like_count, repost_count, peak_engagement
+from feed_posts
+where feed_name = ?
+  and did in (select author.did
+              from graph_edges
+                       join actors viewer on viewer.id = graph_edges.actor_id
+                       join actors author on author.id = graph_edges.subject_id
+              where viewer.did = ?
+                and graph_edges.kind = 1)
+  and +time_us <= ?
+  and +time_us > ?
+  and not exists (select 1
+                  from accounts
+                  where accounts.did = feed_posts.did
+                    and accounts.active = false)
+  and (not ?
+    or not exists (select 1
+                   from graph_edges
+                            join actors viewer on viewer.did = ?
+                            join actors author on author.did = feed_posts.did
+                   where graph_edges.kind = 2
+                     and ((graph_edges.actor_id = viewer.id and graph_edges.subject_id = author.id)
+                       or (graph_edges.actor_id = author.id and graph_edges.subject_id = viewer.id))))
+order by +peak_engagement desc, time_us desc
+limit ?
+`
+
+type GetFollowingTopFeedPostsParams struct {
+	FeedName    string
+	Viewer      string
+	AsOf        int64
+	After       int64
+	HideBlocked bool
+	Limit       int64
+}
+
+// The unary plus keeps SQLite from walking the feed in time order, so the
+// query is driven by the viewer's follows rather than the size of the feed.
+func (q *Queries) GetFollowingTopFeedPosts(ctx context.Context, arg GetFollowingTopFeedPostsParams) ([]FeedPost, error) {
+	rows, err := q.db.QueryContext(ctx, getFollowingTopFeedPosts,
+		arg.FeedName,
+		arg.Viewer,
+		arg.AsOf,
+		arg.After,
+		arg.HideBlocked,
+		arg.Viewer,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -52,6 +376,360 @@ func (q *Queries) GetFeedPosts(ctx context.Context, arg GetFeedPostsParams) ([]F
 			&i.TimeUs,
 			&i.Did,
 			&i.Rkey,
+			&i.LikeCount,
+			&i.RepostCount,
+			&i.PeakEngagement,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTopFeedPosts = `-- name: GetTopFeedPosts :many
+select feed_name, time_us, did, rkey, like_count, repost_count, peak_engagement
+from feed_posts
+where feed_name = ?
+  and time_us <= ?
+  and time_us > ?
+  and not exists (select 1
+                  from accounts
+                  where accounts.did = feed_posts.did
+                    and accounts.active = false)
+  and (not ?
+    or not exists (select 1
+                   from graph_edges
+                            join actors viewer on viewer.did = ?
+                            join actors author on author.did = feed_posts.did
+                   where graph_edges.kind = 2
+                     and ((graph_edges.actor_id = viewer.id and graph_edges.subject_id = author.id)
+                       or (graph_edges.actor_id = author.id and graph_edges.subject_id = viewer.id))))
+order by peak_engagement desc, time_us desc
+limit ?
+`
+
+type GetTopFeedPostsParams struct {
+	FeedName    string
+	AsOf        int64
+	After       int64
+	HideBlocked bool
+	Viewer      string
+	Limit       int64
+}
+
+func (q *Queries) GetTopFeedPosts(ctx context.Context, arg GetTopFeedPostsParams) ([]FeedPost, error) {
+	rows, err := q.db.QueryContext(ctx, getTopFeedPosts,
+		arg.FeedName,
+		arg.AsOf,
+		arg.After,
+		arg.HideBlocked,
+		arg.Viewer,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeedPost
+	for rows.Next() {
+		var i FeedPost
+		if err := rows.Scan(
+			&i.FeedName,
+			&i.TimeUs,
+			&i.Did,
+			&i.Rkey,
+			&i.LikeCount,
+			&i.RepostCount,
+			&i.PeakEngagement,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const insertActor = `-- name: InsertActor :exec
+insert
+into actors (did)
+values (?)
+on conflict do nothing
+`
+
+func (q *Queries) InsertActor(ctx context.Context, did string) error {
+	_, err := q.db.ExecContext(ctx, insertActor, did)
+	return err
+}
+
+const insertDeadLetter = `-- name: InsertDeadLetter :exec
+insert
+into dead_letters (time_us, did, event, error, attempts, failed_at)
+values (?, ?, ?, ?, ?, ?)
+`
+
+type InsertDeadLetterParams struct {
+	TimeUs   int64
+	Did      string
+	Event    string
+	Error    string
+	Attempts int64
+	FailedAt int64
+}
+
+func (q *Queries) InsertDeadLetter(ctx context.Context, arg InsertDeadLetterParams) error {
+	_, err := q.db.ExecContext(ctx, insertDeadLetter,
+		arg.TimeUs,
+		arg.Did,
+		arg.Event,
+		arg.Error,
+		arg.Attempts,
+		arg.FailedAt,
+	)
+	return err
+}
+
+const insertEngagement = `-- name: InsertEngagement :execrows
+insert
+into engagements (did, collection, rkey, subject_did, subject_rkey)
+values (?, ?, ?, ?, ?)
+on conflict do nothing
+`
+
+type InsertEngagementParams struct {
+	Did         string
+	Collection  string
+	Rkey        string
+	SubjectDid  string
+	SubjectRkey string
+}
+
+func (q *Queries) InsertEngagement(ctx context.Context, arg InsertEngagementParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, insertEngagement,
+		arg.Did,
+		arg.Collection,
+		arg.Rkey,
+		arg.SubjectDid,
+		arg.SubjectRkey,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const insertGraphEdge = `-- name: InsertGraphEdge :exec
+insert
+into graph_edges (actor_id, kind, rkey, subject_id)
+select actor.id, cast(? as integer), cast(? as text), subject.id
+from actors actor,
+     actors subject
+where actor.did = ?
+  and subject.did = ?
+on conflict do nothing
+`
+
+type InsertGraphEdgeParams struct {
+	Kind       int64
+	Rkey       string
+	Did        string
+	SubjectDid string
+}
+
+func (q *Queries) InsertGraphEdge(ctx context.Context, arg InsertGraphEdgeParams) error {
+	_, err := q.db.ExecContext(ctx, insertGraphEdge,
+		arg.Kind,
+		arg.Rkey,
+		arg.Did,
+		arg.SubjectDid,
+	)
+	return err
+}
+
+const listDeadLetters = `-- name: ListDeadLetters :many
+select id, time_us, did, event, error, attempts, failed_at
+from dead_letters
+where id > ?
+order by id
+limit ?
+`
+
+type ListDeadLettersParams struct {
+	ID    int64
+	Limit int64
+}
+
+func (q *Queries) ListDeadLetters(ctx context.Context, arg ListDeadLettersParams) ([]DeadLetter, error) {
+	rows, err := q.db.QueryContext(ctx, listDeadLetters, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeadLetter
+	for rows.Next() {
+		var i DeadLetter
+		if err := rows.Scan(
+			&i.ID,
+			&i.TimeUs,
+			&i.Did,
+			&i.Event,
+			&i.Error,
+			&i.Attempts,
+			&i.FailedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFeeds = `-- name: ListFeeds :many
+select feeds.feed_name,
+       feeds.latest_cursor,
+       (select count(*)
+        from feed_posts
+        where feed_posts.feed_name = feeds.feed_name) as post_count
+from feeds
+order by feeds.feed_name
+`
+
+type ListFeedsRow struct {
+	FeedName     string
+	LatestCursor sql.NullInt64
+	PostCount    int64
+}
+
+func (q *Queries) ListFeeds(ctx context.Context) ([]ListFeedsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listFeeds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListFeedsRow
+	for rows.Next() {
+		var i ListFeedsRow
+		if err := rows.Scan(&i.FeedName, &i.LatestCursor, &i.PostCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGraphActors = `-- name: ListGraphActors :many
+select actor.did
+from graph_edges
+         join actors actor on actor.id = graph_edges.actor_id
+         join actors subject on subject.id = graph_edges.subject_id
+where subject.did = ?
+  and graph_edges.kind = ?
+  and graph_edges.actor_id > coalesce((select id from actors where did = ?), 0)
+group by graph_edges.actor_id, actor.did
+order by graph_edges.actor_id
+limit ?
+`
+
+type ListGraphActorsParams struct {
+	SubjectDid string
+	Kind       int64
+	After      string
+	Limit      int64
+}
+
+func (q *Queries) ListGraphActors(ctx context.Context, arg ListGraphActorsParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listGraphActors,
+		arg.SubjectDid,
+		arg.Kind,
+		arg.After,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var did string
+		if err := rows.Scan(&did); err != nil {
+			return nil, err
+		}
+		items = append(items, did)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGraphEdgesBetween = `-- name: ListGraphEdgesBetween :many
+select actor.did as did, graph_edges.kind, graph_edges.rkey, subject.did as subject_did
+from graph_edges
+         join actors actor on actor.id = graph_edges.actor_id
+         join actors subject on subject.id = graph_edges.subject_id
+where (actor.did = ? and subject.did = ?)
+   or (actor.did = ? and subject.did = ?)
+order by graph_edges.kind, actor.did, graph_edges.rkey
+`
+
+type ListGraphEdgesBetweenParams struct {
+	Did      string
+	OtherDid string
+}
+
+type ListGraphEdgesBetweenRow struct {
+	Did        string
+	Kind       int64
+	Rkey       string
+	SubjectDid string
+}
+
+func (q *Queries) ListGraphEdgesBetween(ctx context.Context, arg ListGraphEdgesBetweenParams) ([]ListGraphEdgesBetweenRow, error) {
+	rows, err := q.db.QueryContext(ctx, listGraphEdgesBetween,
+		arg.Did,
+		arg.OtherDid,
+		arg.OtherDid,
+		arg.Did,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListGraphEdgesBetweenRow
+	for rows.Next() {
+		var i ListGraphEdgesBetweenRow
+		if err := rows.Scan(
+			&i.Did,
+			&i.Kind,
+			&i.Rkey,
+			&i.SubjectDid,
 		); err != nil {
 			return nil, err
 		}
@@ -66,6 +744,117 @@ func (q *Queries) GetFeedPosts(ctx context.Context, arg GetFeedPostsParams) ([]F
 	return items, nil
 }
 
+const listGraphSubjects = `-- name: ListGraphSubjects :many
+select subject.did
+from graph_edges
+         join actors actor on actor.id = graph_edges.actor_id
+         join actors subject on subject.id = graph_edges.subject_id
+where actor.did = ?
+  and graph_edges.kind = ?
+  and graph_edges.subject_id > coalesce((select id from actors where did = ?), 0)
+group by graph_edges.subject_id, subject.did
+order by graph_edges.subject_id
+limit ?
+`
+
+type ListGraphSubjectsParams struct {
+	Did   string
+	Kind  int64
+	After string
+	Limit int64
+}
+
+func (q *Queries) ListGraphSubjects(ctx context.Context, arg ListGraphSubjectsParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listGraphSubjects,
+		arg.Did,
+		arg.Kind,
+		arg.After,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var did string
+		if err := rows.Scan(&did); err != nil {
+			return nil, err
+		}
+		items = append(items, did)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const pruneEngagements = `-- name: PruneEngagements :execrows
+delete
+from engagements
+where (did, collection, rkey) in (select did, collection, rkey
+                                  from engagements
+                                  where not exists (select 1
+                                                    from feed_posts
+                                                    where feed_posts.did = engagements.subject_did
+                                                      and feed_posts.rkey = engagements.subject_rkey)
+                                  limit ?)
+`
+
+func (q *Queries) PruneEngagements(ctx context.Context, limit int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, pruneEngagements, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const pruneFeedPosts = `-- name: PruneFeedPosts :execrows
+delete
+from feed_posts
+where (feed_name, did, rkey) in (select feed_name, did, rkey
+                                 from feed_posts
+                                 where feed_name = ?
+                                   and time_us < ?
+                                 limit ?)
+`
+
+type PruneFeedPostsParams struct {
+	FeedName string
+	TimeUs   int64
+	Limit    int64
+}
+
+func (q *Queries) PruneFeedPosts(ctx context.Context, arg PruneFeedPostsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, pruneFeedPosts, arg.FeedName, arg.TimeUs, arg.Limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateDeadLetterFailure = `-- name: UpdateDeadLetterFailure :exec
+update dead_letters
+set error     = ?,
+    attempts  = attempts + 1,
+    failed_at = ?
+where id = ?
+`
+
+type UpdateDeadLetterFailureParams struct {
+	Error    string
+	FailedAt int64
+	ID       int64
+}
+
+func (q *Queries) UpdateDeadLetterFailure(ctx context.Context, arg UpdateDeadLetterFailureParams) error {
+	_, err := q.db.ExecContext(ctx, updateDeadLetterFailure, arg.Error, arg.FailedAt, arg.ID)
+	return err
+}
+
 const updateFeedCursor = `-- name: UpdateFeedCursor :exec
 update feeds
 set latest_cursor = ?
@@ -82,6 +871,63 @@ func (q *Queries) UpdateFeedCursor(ctx context.Context, arg UpdateFeedCursorPara
 	return err
 }
 
+const updatePostEngagement = `-- name: UpdatePostEngagement :exec
+update feed_posts
+set like_count      = like_count + cast(? as integer),
+    repost_count    = repost_count + cast(? as integer),
+    peak_engagement = max(peak_engagement,
+                          like_count + repost_count + cast(? as integer) +
+                          cast(? as integer))
+where did = ?
+  and rkey = ?
+`
+
+type UpdatePostEngagementParams struct {
+	LikeDelta   int64
+	RepostDelta int64
+	Did         string
+	Rkey        string
+}
+
+func (q *Queries) UpdatePostEngagement(ctx context.Context, arg UpdatePostEngagementParams) error {
+	_, err := q.db.ExecContext(ctx, updatePostEngagement,
+		arg.LikeDelta,
+		arg.RepostDelta,
+		arg.LikeDelta,
+		arg.RepostDelta,
+		arg.Did,
+		arg.Rkey,
+	)
+	return err
+}
+
+const upsertAccount = `-- name: UpsertAccount :exec
+insert
+into accounts (did, active, status, time_us)
+values (?, ?, ?, ?)
+on conflict (did) do update set active  = excluded.active,
+                                status  = excluded.status,
+                                time_us = excluded.time_us
+where excluded.time_us >= accounts.time_us
+`
+
+type UpsertAccountParams struct {
+	Did    string
+	Active bool
+	Status sql.NullString
+	TimeUs int64
+}
+
+func (q *Queries) UpsertAccount(ctx context.Context, arg UpsertAccountParams) error {
+	_, err := q.db.ExecContext(ctx, upsertAccount,
+		arg.Did,
+		arg.Active,
+		arg.Status,
+		arg.TimeUs,
+	)
+	return err
+}
+
 const upsertFeed = `-- name: UpsertFeed :exec
 insert into feeds (feed_name)
 values (?)
@@ -97,7 +943,7 @@ const upsertFeedPost = `-- name: UpsertFeedPost :exec
 insert
 into feed_posts (feed_name, time_us, did, rkey)
 values (?, ?, ?, ?)
-on conflict (feed_name, did, rkey) do nothing
+on conflict (feed_name, did, rkey) do update set time_us = excluded.time_us
 `
 
 type UpsertFeedPostParams struct {
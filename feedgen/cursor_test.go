@@ -0,0 +1,116 @@
+package feedgen
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"jetstream-feed-generator/store"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []pageCursor{
+		{Ranking: RankingChronological, TimeUs: 1700000000000000, Did: "did:plc:a", Rkey: "3k"},
+		{Ranking: RankingHot, AsOf: 1700000000000000, Score: 0.125, Did: "did:plc:a", Rkey: "3k"},
+		{Ranking: RankingTop, AsOf: 1700000000000000, Did: "did:web:example.com", Rkey: "3k"},
+	}
+	for _, want := range tests {
+		t.Run(want.Ranking, func(t *testing.T) {
+			got, err := decodeCursor(want.encode(), want.Ranking)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want.Version = cursorVersion
+			if got != want {
+				t.Errorf("decoded %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyCursor(t *testing.T) {
+	got, err := decodeCursor("1700000000000000", RankingChronological)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TimeUs != 1700000000000000 || got.Did != "" {
+		t.Errorf("decoded %+v, want TimeUs 1700000000000000 and no key", got)
+	}
+}
+
+func TestDecodeCursorErrors(t *testing.T) {
+	encodeJSON := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	tests := []struct {
+		name    string
+		cursor  string
+		ranking string
+		want    string
+	}{
+		{"bad base64", "not base64!", RankingChronological, "not base64"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"v":1}`)), RankingChronological, "not base64"},
+		{"not JSON", encodeJSON("1700000000000000"), RankingTop, "malformed"},
+		{"wrong version", encodeJSON(`{"v":2,"r":"top","a":1,"d":"did:plc:a","k":"3k"}`), RankingTop, "unsupported version 2"},
+		{"no version", encodeJSON(`{"r":"top","a":1,"d":"did:plc:a","k":"3k"}`), RankingTop, "unsupported version 0"},
+		{"other ranking", pageCursor{Ranking: RankingHot, AsOf: 1}.encode(), RankingTop, `issued by "hot" ranking`},
+		{"legacy cursor for scored ranking", "1700000000000000", RankingTop, "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor, tt.ranking)
+			if !errors.Is(err, ErrInvalidCursor) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %v containing %q", err, ErrInvalidCursor, tt.want)
+			}
+		})
+	}
+}
+
+// TestCursorBreaksTiesAtPageBoundary pages through posts that share a
+// timestamp and a score, with a page boundary falling between them.
+func TestCursorBreaksTiesAtPageBoundary(t *testing.T) {
+	timeUs := time.Now().Add(-time.Hour).UnixMicro()
+	dids := []string{"did:plc:a", "did:plc:b", "did:plc:c"}
+	tests := []struct {
+		ranking RankingConfig
+		want    [][]string
+	}{
+		{RankingConfig{}, [][]string{{"did:plc:c", "did:plc:b"}, {"did:plc:a"}}},
+		{RankingConfig{Type: RankingTop}, [][]string{{"did:plc:a", "did:plc:b"}, {"did:plc:c"}}},
+	}
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.ranking.Type, RankingChronological), func(t *testing.T) {
+			st := openStore(t)
+			write(t, st, func(ctx context.Context, w store.Writer) error {
+				for _, did := range dids {
+					post := store.FeedPost{FeedName: testFeed, TimeUs: timeUs, Did: did, Rkey: "3k"}
+					if err := w.UpsertFeedPost(ctx, post); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			ranking, err := NewRanking(tt.ranking)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var pages [][]string
+			for _, posts := range pageAll(t, st, ranking, nil) {
+				var dids []string
+				for _, p := range posts {
+					dids = append(dids, p.Did)
+				}
+				pages = append(pages, dids)
+			}
+			if !slices.EqualFunc(pages, tt.want, slices.Equal) {
+				t.Errorf("pages = %v, want %v", pages, tt.want)
+			}
+		})
+	}
+}
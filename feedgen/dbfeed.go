@@ -2,10 +2,10 @@ package feedgen
 
 import (
 	"context"
-	"fmt"
-	db "jetstream-feed-generator/db/sqlc"
+	"errors"
+	"jetstream-feed-generator/metrics"
+	"jetstream-feed-generator/store"
 	"log/slog"
-	"strconv"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/bsky"
@@ -14,7 +14,15 @@ import (
 type DbFeed struct {
 	FeedActorDID string
 	FeedName     string
-	Q            *db.Queries
+	Store        store.Store
+	// Ranking orders the feed's posts; nil means Chronological.
+	Ranking Ranking
+	// HideBlocked leaves out posts by accounts that have blocked the viewer
+	// or that the viewer has blocked, and FollowingOnly posts by accounts
+	// the viewer doesn't follow. Both rely on the follows and blocks the
+	// consumer has recorded.
+	HideBlocked   bool
+	FollowingOnly bool
 }
 
 func (dbf DbFeed) GetPage(
@@ -23,27 +31,37 @@ func (dbf DbFeed) GetPage(
 ) ([]*bsky.FeedDefs_SkeletonFeedPost, *string, error) {
 	slog.Info("generating feed", "component", "dbfeed",
 		"feed", feed, "user_did", userDID, "limit", limit, "cursor", cursor)
-	// not using userDID, same posts for everybody
-	cursorAsInt := time.Now().UnixMicro()
-	var err error
-
-	if cursor != "" {
-		cursorAsInt, err = strconv.ParseInt(cursor, 10, 64)
-		if err != nil {
-			return nil, nil, fmt.Errorf("cursor is not an integer: %w", err)
-		}
+	start := time.Now()
+	posts, newCursor, err := dbf.getPage(ctx, userDID, limit, cursor)
+	status := "ok"
+	switch {
+	case errors.Is(err, ErrInvalidCursor):
+		status = "invalid_cursor"
+	case err != nil:
+		status = "error"
 	}
+	metrics.FeedSkeletonRequests.WithLabelValues(dbf.FeedName, status).Inc()
+	metrics.FeedSkeletonDuration.WithLabelValues(dbf.FeedName).Observe(time.Since(start).Seconds())
+	return posts, newCursor, err
+}
 
-	posts := make([]*bsky.FeedDefs_SkeletonFeedPost, 0)
-	dbPosts, err := dbf.Q.GetFeedPosts(ctx, db.GetFeedPostsParams{
-		FeedName: dbf.FeedName,
-		TimeUs:   cursorAsInt,
-		Limit:    limit,
-	})
+func (dbf DbFeed) getPage(ctx context.Context, userDID string, limit int64, cursor string) ([]*bsky.FeedDefs_SkeletonFeedPost, *string, error) {
+	ranking := dbf.Ranking
+	if ranking == nil {
+		ranking = Chronological{}
+	}
+	query := store.GetFeedPostsParams{
+		FeedName:      dbf.FeedName,
+		Viewer:        userDID,
+		HideBlocked:   dbf.HideBlocked,
+		FollowingOnly: dbf.FollowingOnly,
+	}
+	dbPosts, next, err := ranking.Page(ctx, dbf.Store, query, limit, cursor)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get posts: %w", err)
+		return nil, nil, err
 	}
 
+	posts := make([]*bsky.FeedDefs_SkeletonFeedPost, 0, len(dbPosts))
 	for _, post := range dbPosts {
 		posts = append(posts, &bsky.FeedDefs_SkeletonFeedPost{
 			Post: "at://" + post.Did + "/app.bsky.feed.post/" + post.Rkey,
@@ -51,9 +69,8 @@ func (dbf DbFeed) GetPage(
 	}
 
 	var newCursor *string
-	if len(dbPosts) > 0 {
-		newCursor = new(string)
-		*newCursor = strconv.FormatInt(dbPosts[len(dbPosts)-1].TimeUs, 10)
+	if next != "" {
+		newCursor = &next
 	}
 	return posts, newCursor, nil
 }
@@ -1,16 +1,18 @@
 package feedgen
 
 import (
+	"cmp"
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
-	db "jetstream-feed-generator/db/sqlc"
+	"jetstream-feed-generator/admin"
+	"jetstream-feed-generator/store"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"time"
 
+	"github.com/bluesky-social/indigo/api/bsky"
 	"github.com/ericvolp12/go-bsky-feed-generator/pkg/auth"
 	"github.com/ericvolp12/go-bsky-feed-generator/pkg/feedrouter"
 	ginendpoints "github.com/ericvolp12/go-bsky-feed-generator/pkg/gin"
@@ -22,25 +24,63 @@ type Config struct {
 	FeedActorDID    string
 	ServiceEndpoint string
 	Port            int
-	FeedNames       []string
-	DB              *sql.DB
+	Feeds           []FeedConfig
+	Store           store.Store
+	Health          admin.Health
+}
+
+// FeedConfig describes one feed served by the feed generator.
+type FeedConfig struct {
+	Name string
+	// DisplayName and Description are published in the feed's generator
+	// record; see GeneratorRecord.
+	DisplayName string
+	Description string
+	Ranking     RankingConfig
+	// HideBlocked and FollowingOnly personalize the feed; see DbFeed.
+	HideBlocked   bool
+	FollowingOnly bool
+}
+
+// GeneratorRecord returns the app.bsky.feed.generator record that lists the
+// feed in Bluesky clients, served by the feed generator at serviceDID. Feeds
+// without a display name are listed under their name.
+func (feed FeedConfig) GeneratorRecord(serviceDID string, createdAt time.Time) *bsky.FeedGenerator {
+	record := &bsky.FeedGenerator{
+		LexiconTypeID: "app.bsky.feed.generator",
+		CreatedAt:     createdAt.UTC().Format(time.RFC3339),
+		Did:           serviceDID,
+		DisplayName:   cmp.Or(feed.DisplayName, feed.Name),
+	}
+	if feed.Description != "" {
+		record.Description = &feed.Description
+	}
+	return record
+}
+
+// ServiceDID returns the did:web of the feed generator served at
+// serviceEndpoint.
+func ServiceDID(serviceEndpoint string) (string, error) {
+	serviceURL, err := url.Parse(serviceEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("error parsing service endpoint: %w", err)
+	}
+	if serviceURL.Hostname() == "" {
+		return "", fmt.Errorf("service endpoint must have a hostname")
+	}
+	return "did:web:" + serviceURL.Hostname(), nil
 }
 
 func RunFeedGenerator(ctx context.Context, config Config) error {
 	// Set the acceptable DIDs for the feed generator to respond to
 	// We'll default to the FeedActorDID and the Service Endpoint as a did:web
-	serviceURL, err := url.Parse(config.ServiceEndpoint)
+	serviceWebDID, err := ServiceDID(config.ServiceEndpoint)
 	if err != nil {
-		return fmt.Errorf("error parsing service endpoint: %w", err)
-	}
-	if serviceURL.Hostname() == "" {
-		return fmt.Errorf("service endpoint must have a hostname")
+		return err
 	}
 
 	logger := slog.With("component", "feedgen")
 
-	serviceWebDID := "did:web:" + serviceURL.Hostname()
-
 	acceptableDIDs := []string{config.FeedActorDID, serviceWebDID}
 
 	feedRouter, err := feedrouter.NewFeedRouter(ctx, config.FeedActorDID,
@@ -49,13 +89,21 @@ func RunFeedGenerator(ctx context.Context, config Config) error {
 		return fmt.Errorf("error creating feed router: %w", err)
 	}
 
-	queries := db.New(config.DB)
-	for _, feedName := range config.FeedNames {
-		feedRouter.AddFeed([]string{feedName}, DbFeed{
-			FeedActorDID: config.FeedActorDID,
-			FeedName:     feedName,
-			Q:            queries,
+	for _, feed := range config.Feeds {
+		ranking, err := NewRanking(feed.Ranking)
+		if err != nil {
+			return fmt.Errorf("feed %s: %w", feed.Name, err)
+		}
+		feedRouter.AddFeed([]string{feed.Name}, DbFeed{
+			FeedActorDID:  config.FeedActorDID,
+			FeedName:      feed.Name,
+			Store:         config.Store,
+			Ranking:       ranking,
+			HideBlocked:   feed.HideBlocked,
+			FollowingOnly: feed.FollowingOnly,
 		})
+		logger.Info("serving feed", "feed", feed.Name,
+			"ranking", cmp.Or(feed.Ranking.Type, RankingChronological))
 	}
 
 	// Create a gin router with default middleware for logging and recovery
@@ -65,6 +113,7 @@ func RunFeedGenerator(ctx context.Context, config Config) error {
 
 	// Add unauthenticated routes for feed generator
 	ep := ginendpoints.NewEndpoints(feedRouter)
+	admin.RegisterRoutes(router, config.Health)
 	router.GET("/.well-known/did.json", ep.GetWellKnownDID)
 	router.GET("/xrpc/app.bsky.feed.describeFeedGenerator", ep.DescribeFeeds)
 
@@ -82,7 +131,7 @@ func RunFeedGenerator(ctx context.Context, config Config) error {
 	router.Use(auther.AuthenticateGinRequestViaJWT)
 
 	// Add authenticated routes for feed generator
-	router.GET("/xrpc/app.bsky.feed.getFeedSkeleton", ep.GetFeedSkeleton)
+	router.GET("/xrpc/app.bsky.feed.getFeedSkeleton", getFeedSkeleton(feedRouter))
 
 	logger.Info("starting server", "port", config.Port, "service_did", serviceWebDID)
 
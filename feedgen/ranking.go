@@ -0,0 +1,292 @@
+package feedgen
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"jetstream-feed-generator/store"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ranking types that can be selected in a feed's ranking config.
+const (
+	RankingChronological = "chronological"
+	RankingHot           = "hot"
+	RankingTop           = "top"
+	RankingRandom        = "random"
+)
+
+// Defaults for settings left out of a ranking config.
+const (
+	defaultHotWindow  = 48 * time.Hour
+	defaultTopWindow  = 24 * time.Hour
+	defaultGravity    = 1.8
+	defaultCandidates = 1000
+)
+
+// RankingConfig selects how a feed's posts are ordered.
+type RankingConfig struct {
+	// Type is one of the Ranking constants; empty means chronological.
+	Type string
+	// Window limits hot, top and random rankings to posts from this long
+	// before the first page was requested. Zero uses the ranking's default,
+	// which for random is no limit.
+	Window time.Duration
+	// Gravity is how quickly hot scores decay with age.
+	Gravity float64
+	// Candidates bounds how many posts hot, top and random rankings order
+	// for each page: the most liked and reposted for top, the newest for
+	// random, and that many of each for hot.
+	Candidates int64
+}
+
+func (rc RankingConfig) Validate() error {
+	if rc.Window < 0 || rc.Gravity < 0 || rc.Candidates < 0 {
+		return fmt.Errorf("ranking settings must not be negative")
+	}
+	switch rc.Type {
+	case "", RankingChronological, RankingHot, RankingTop, RankingRandom:
+		return nil
+	default:
+		return fmt.Errorf("unknown ranking %q", rc.Type)
+	}
+}
+
+// UsesEngagement reports whether the ranking orders posts by their likes and
+// reposts, which the consumer only counts with engagement enabled.
+func (rc RankingConfig) UsesEngagement() bool {
+	return rc.Type == RankingHot || rc.Type == RankingTop
+}
+
+// Ranking orders a feed's posts and pages through them. Cursors record the
+// ranking that issued them; other rankings reject them with
+// ErrInvalidCursor.
+type Ranking interface {
+	// Page returns up to limit of the posts selected by query following
+	// cursor, which is empty for the first page, and the cursor for the next
+	// page. The ranking sets the query's bounds and limit; its Viewer is
+	// the requesting account, or empty if unknown.
+	Page(ctx context.Context, st store.Store, query store.GetFeedPostsParams, limit int64, cursor string) ([]store.FeedPost, string, error)
+}
+
+// NewRanking builds the Ranking selected by the config.
+func NewRanking(rc RankingConfig) (Ranking, error) {
+	candidates := cmp.Or(rc.Candidates, defaultCandidates)
+	switch rc.Type {
+	case "", RankingChronological:
+		return Chronological{}, nil
+	case RankingHot:
+		gravity := cmp.Or(rc.Gravity, defaultGravity)
+		return scoredRanking{
+			name:         RankingHot,
+			window:       cmp.Or(rc.Window, defaultHotWindow),
+			candidates:   candidates,
+			byEngagement: true,
+			withNewest:   true,
+			score: func(post store.FeedPost, asOf int64, _ string) float64 {
+				ageHours := float64(asOf-post.TimeUs) / float64(time.Hour.Microseconds())
+				return float64(engagement(post)+1) / math.Pow(ageHours+2, gravity)
+			},
+		}, nil
+	case RankingTop:
+		return scoredRanking{
+			name:         RankingTop,
+			window:       cmp.Or(rc.Window, defaultTopWindow),
+			candidates:   candidates,
+			byEngagement: true,
+			score: func(post store.FeedPost, _ int64, _ string) float64 {
+				return float64(engagement(post))
+			},
+		}, nil
+	case RankingRandom:
+		return scoredRanking{
+			name:       RankingRandom,
+			window:     rc.Window,
+			candidates: candidates,
+			score:      shuffleScore,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown ranking %q", rc.Type)
+	}
+}
+
+// engagement is the measure hot and top rankings are based on: the most
+// likes plus reposts the post has had, so removing them doesn't lower its
+// score.
+func engagement(post store.FeedPost) int64 {
+	return post.PeakEngagement
+}
+
+// shuffleScore orders posts randomly but repeatably for each viewer and
+// first page request, so every page follows the same order.
+func shuffleScore(post store.FeedPost, asOf int64, userDID string) float64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%d|%s|%s", userDID, asOf, post.Did, post.Rkey)
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// Chronological orders posts newest first, with ties broken by DID and
+// record key. Its cursor holds the TimeUs and key of the last post returned.
+type Chronological struct{}
+
+func (Chronological) Page(ctx context.Context, st store.Store, query store.GetFeedPostsParams, limit int64, cursor string) ([]store.FeedPost, string, error) {
+	after := pageCursor{TimeUs: time.Now().UnixMicro()}
+	if cursor != "" {
+		var err error
+		if after, err = decodeCursor(cursor, RankingChronological); err != nil {
+			return nil, "", err
+		}
+	}
+	query.Before, query.BeforeDid, query.BeforeRkey = after.TimeUs, after.Did, after.Rkey
+	query.Limit = limit
+	posts, err := st.GetFeedPosts(ctx, query)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get posts: %w", err)
+	}
+	if len(posts) == 0 {
+		return posts, "", nil
+	}
+	last := posts[len(posts)-1]
+	next := pageCursor{
+		Ranking: RankingChronological,
+		TimeUs:  last.TimeUs,
+		Did:     last.Did,
+		Rkey:    last.Rkey,
+	}
+	return posts, next.encode(), nil
+}
+
+// scoredRanking orders a bounded set of candidate posts by a score computed
+// in Go, highest first, with ties broken by DID and record key.
+//
+// Scores are computed as of the time of the first page, which the cursor
+// carries along with the score and key of the last post returned, and posts
+// added since then are left out. Scores never go down between pages, since
+// they are computed from a fixed time and from peak engagement, so a post
+// already shown never comes round again on a later page, although one that
+// overtakes the last post shown is skipped.
+type scoredRanking struct {
+	name       string
+	window     time.Duration
+	candidates int64
+	// byEngagement picks the candidates with the most likes and reposts
+	// rather than the newest. withNewest adds the newest as well, so fresh
+	// posts can compete before they have collected any.
+	byEngagement bool
+	withNewest   bool
+	score        func(post store.FeedPost, asOf int64, userDID string) float64
+}
+
+type scoredPost struct {
+	store.FeedPost
+	score float64
+}
+
+func (r scoredRanking) Page(ctx context.Context, st store.Store, query store.GetFeedPostsParams, limit int64, cursor string) ([]store.FeedPost, string, error) {
+	asOf := time.Now().UnixMicro()
+	var after *scoredPost
+	if cursor != "" {
+		c, err := decodeCursor(cursor, r.name)
+		if err != nil {
+			return nil, "", err
+		}
+		asOf = c.AsOf
+		after = &scoredPost{FeedPost: store.FeedPost{Did: c.Did, Rkey: c.Rkey}, score: c.Score}
+	}
+
+	candidates, err := r.candidatesAsOf(ctx, st, query, asOf)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scored := make([]scoredPost, 0, len(candidates))
+	for _, post := range candidates {
+		sp := scoredPost{FeedPost: post, score: r.score(post, asOf, query.Viewer)}
+		if after == nil || compareScored(*after, sp) < 0 {
+			scored = append(scored, sp)
+		}
+	}
+	slices.SortFunc(scored, compareScored)
+	if int64(len(scored)) > limit {
+		scored = scored[:limit]
+	}
+
+	posts := make([]store.FeedPost, len(scored))
+	for i, sp := range scored {
+		posts[i] = sp.FeedPost
+	}
+	if len(scored) == 0 {
+		return posts, "", nil
+	}
+	last := scored[len(scored)-1]
+	next := pageCursor{
+		Ranking: r.name,
+		AsOf:    asOf,
+		Score:   last.score,
+		Did:     last.Did,
+		Rkey:    last.Rkey,
+	}
+	return posts, next.encode(), nil
+}
+
+// candidatesAsOf returns the posts to score for a page: those before asOf,
+// and within the window of it, selected as configured.
+func (r scoredRanking) candidatesAsOf(ctx context.Context, st store.Store, query store.GetFeedPostsParams, asOf int64) ([]store.FeedPost, error) {
+	var after int64
+	if r.window > 0 {
+		after = asOf - r.window.Microseconds()
+	}
+	var candidates []store.FeedPost
+	if r.byEngagement {
+		top, err := st.GetTopFeedPosts(ctx, store.GetTopFeedPostsParams{
+			FeedName:      query.FeedName,
+			AsOf:          asOf,
+			After:         after,
+			Viewer:        query.Viewer,
+			HideBlocked:   query.HideBlocked,
+			FollowingOnly: query.FollowingOnly,
+			Limit:         r.candidates,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get top posts: %w", err)
+		}
+		candidates = top
+	}
+	if !r.byEngagement || r.withNewest {
+		query.Before, query.BeforeDid, query.BeforeRkey = asOf+1, "", ""
+		query.After = after
+		query.Limit = r.candidates
+		newest, err := st.GetFeedPosts(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get posts: %w", err)
+		}
+		type key struct{ did, rkey string }
+		seen := make(map[key]bool, len(candidates))
+		for _, post := range candidates {
+			seen[key{post.Did, post.Rkey}] = true
+		}
+		for _, post := range newest {
+			if !seen[key{post.Did, post.Rkey}] {
+				candidates = append(candidates, post)
+			}
+		}
+	}
+	return candidates, nil
+}
+
+func compareScored(a, b scoredPost) int {
+	if a.score != b.score {
+		if a.score > b.score {
+			return -1
+		}
+		return 1
+	}
+	if a.Did != b.Did {
+		return strings.Compare(a.Did, b.Did)
+	}
+	return strings.Compare(a.Rkey, b.Rkey)
+}
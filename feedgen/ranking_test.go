@@ -0,0 +1,283 @@
+package feedgen
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"jetstream-feed-generator/store"
+	"jetstream-feed-generator/store/sqlite"
+)
+
+const (
+	testFeed   = "test-feed"
+	testAuthor = "did:plc:author"
+)
+
+func openStore(t *testing.T) store.Store {
+	t.Helper()
+	st, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	ctx := context.Background()
+	if _, err := st.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertFeed(ctx, testFeed); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func write(t *testing.T, st store.Store, fn func(ctx context.Context, w store.Writer) error) {
+	t.Helper()
+	ctx := context.Background()
+	if err := st.InTx(ctx, func(w store.Writer) error { return fn(ctx, w) }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addPost adds a post made age ago to the test feed.
+func addPost(t *testing.T, st store.Store, rkey string, age time.Duration) {
+	t.Helper()
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		return w.UpsertFeedPost(ctx, store.FeedPost{
+			FeedName: testFeed,
+			TimeUs:   time.Now().Add(-age).UnixMicro(),
+			Did:      testAuthor,
+			Rkey:     rkey,
+		})
+	})
+}
+
+// like has accounts from to to-1 like the post.
+func like(t *testing.T, st store.Store, rkey string, from, to int) {
+	t.Helper()
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		for i := from; i < to; i++ {
+			if err := w.AddEngagement(ctx, store.Engagement{
+				Did:         fmt.Sprintf("did:plc:fan%d", i),
+				Collection:  store.LikeCollection,
+				Rkey:        rkey,
+				SubjectDid:  testAuthor,
+				SubjectRkey: rkey,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// unlike removes the likes of accounts from to to-1.
+func unlike(t *testing.T, st store.Store, rkey string, from, to int) {
+	t.Helper()
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		for i := from; i < to; i++ {
+			if err := w.DeleteEngagement(ctx, fmt.Sprintf("did:plc:fan%d", i), store.LikeCollection, rkey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pageAll pages through the test feed two posts at a time, calling
+// afterFirst, if set, once the first page is returned.
+func pageAll(t *testing.T, st store.Store, ranking Ranking, afterFirst func()) [][]store.FeedPost {
+	t.Helper()
+	var pages [][]store.FeedPost
+	cursor := ""
+	for i := 0; i == 0 || cursor != ""; i++ {
+		if i == 10 {
+			t.Fatalf("still paging after %d pages", len(pages))
+		}
+		posts, next, err := ranking.Page(context.Background(), st, store.GetFeedPostsParams{FeedName: testFeed}, 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) > 0 {
+			pages = append(pages, posts)
+		}
+		if i == 0 && afterFirst != nil {
+			afterFirst()
+		}
+		cursor = next
+	}
+	return pages
+}
+
+type testPost struct {
+	rkey  string
+	age   time.Duration
+	likes int
+}
+
+func TestRankingPages(t *testing.T) {
+	fivePosts := []testPost{
+		{"a", 5 * time.Hour, 5},
+		{"b", 4 * time.Hour, 4},
+		{"c", 3 * time.Hour, 3},
+		{"d", 2 * time.Hour, 2},
+		{"e", time.Hour, 1},
+	}
+	tests := []struct {
+		name    string
+		ranking RankingConfig
+		posts   []testPost
+		// between runs after the first page is returned.
+		between func(t *testing.T, st store.Store)
+		want    [][]string
+		// shuffled compares the posts shown regardless of order.
+		shuffled bool
+	}{
+		{
+			name:  "chronological",
+			posts: fivePosts,
+			between: func(t *testing.T, st store.Store) {
+				addPost(t, st, "new", 0)
+			},
+			want: [][]string{{"e", "d"}, {"c", "b"}, {"a"}},
+		},
+		{
+			name:    "top",
+			ranking: RankingConfig{Type: RankingTop, Window: 24 * time.Hour},
+			posts:   fivePosts,
+			want:    [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			// A post shown on the first page that loses its likes isn't
+			// shown again, and one that overtakes the last post shown is
+			// skipped.
+			name:    "top with likes changing",
+			ranking: RankingConfig{Type: RankingTop, Window: 24 * time.Hour},
+			posts:   fivePosts,
+			between: func(t *testing.T, st store.Store) {
+				unlike(t, st, "a", 0, 5)
+				like(t, st, "e", 1, 10)
+			},
+			want: [][]string{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			// Old posts are the most liked, but a fresh post makes the
+			// candidates and outranks them.
+			name:    "hot",
+			ranking: RankingConfig{Type: RankingHot, Candidates: 2},
+			posts: []testPost{
+				{"x", 30 * time.Hour, 3},
+				{"y", 31 * time.Hour, 2},
+				{"z", 32 * time.Hour, 1},
+				{"fresh", 10 * time.Minute, 0},
+			},
+			between: func(t *testing.T, st store.Store) {
+				unlike(t, st, "x", 0, 3)
+			},
+			want: [][]string{{"fresh", "x"}, {"y"}},
+		},
+		{
+			name:    "random",
+			ranking: RankingConfig{Type: RankingRandom},
+			posts:   fivePosts,
+			between: func(t *testing.T, st store.Store) {
+				addPost(t, st, "new", 0)
+			},
+			want:     [][]string{{"a", "b", "c", "d", "e"}},
+			shuffled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := openStore(t)
+			for _, p := range tt.posts {
+				addPost(t, st, p.rkey, p.age)
+				like(t, st, p.rkey, 0, p.likes)
+			}
+			ranking, err := NewRanking(tt.ranking)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var afterFirst func()
+			if tt.between != nil {
+				afterFirst = func() { tt.between(t, st) }
+			}
+			var pages [][]string
+			for _, posts := range pageAll(t, st, ranking, afterFirst) {
+				var rkeys []string
+				for _, p := range posts {
+					rkeys = append(rkeys, p.Rkey)
+				}
+				pages = append(pages, rkeys)
+			}
+
+			if tt.shuffled {
+				got := slices.Concat(pages...)
+				slices.Sort(got)
+				if !slices.Equal(got, tt.want[0]) {
+					t.Errorf("pages = %v, want each of %v once", pages, tt.want[0])
+				}
+				return
+			}
+			if !slices.EqualFunc(pages, tt.want, slices.Equal) {
+				t.Errorf("pages = %v, want %v", pages, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankingFollowingOnly(t *testing.T) {
+	const viewer = "did:plc:viewer"
+	// With two candidates, the most liked post would take the place of a
+	// followed one if posts were left out after picking candidates.
+	for _, rc := range []RankingConfig{{}, {Type: RankingTop, Candidates: 2}, {Type: RankingHot, Candidates: 2}} {
+		t.Run(cmp.Or(rc.Type, RankingChronological), func(t *testing.T) {
+			st := openStore(t)
+			timeUs := time.Now().Add(-time.Hour).UnixMicro()
+			write(t, st, func(ctx context.Context, w store.Writer) error {
+				for i, did := range []string{"did:plc:followed", "did:plc:other", "did:plc:followed"} {
+					post := store.FeedPost{FeedName: testFeed, TimeUs: timeUs + int64(i), Did: did, Rkey: fmt.Sprint(i)}
+					if err := w.UpsertFeedPost(ctx, post); err != nil {
+						return err
+					}
+				}
+				for i := range 5 {
+					fan := store.Engagement{Did: fmt.Sprintf("did:plc:fan%d", i), Collection: store.LikeCollection, Rkey: "1", SubjectDid: "did:plc:other", SubjectRkey: "1"}
+					if err := w.AddEngagement(ctx, fan); err != nil {
+						return err
+					}
+				}
+				// Following an account twice doesn't show its posts twice.
+				for _, rkey := range []string{"f1", "f2"} {
+					edge := store.GraphEdge{Did: viewer, Collection: store.FollowCollection, Rkey: rkey, SubjectDid: "did:plc:followed"}
+					if err := w.AddGraphEdge(ctx, edge); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			ranking, err := NewRanking(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := store.GetFeedPostsParams{FeedName: testFeed, Viewer: viewer, FollowingOnly: true}
+			posts, _, err := ranking.Page(context.Background(), st, query, 10, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rkeys []string
+			for _, p := range posts {
+				rkeys = append(rkeys, p.Rkey)
+			}
+			slices.Sort(rkeys)
+			if !slices.Equal(rkeys, []string{"0", "2"}) {
+				t.Errorf("posts = %v, want 0 and 2 by the followed account", rkeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package feedgen
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/ericvolp12/go-bsky-feed-generator/pkg/feedrouter"
+	"github.com/gin-gonic/gin"
+)
+
+// Limits on the number of posts per page.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 250
+)
+
+// xrpcError is the body of an XRPC error response.
+type xrpcError struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+}
+
+// getFeedSkeleton serves app.bsky.feed.getFeedSkeleton in place of the
+// go-bsky-feed-generator endpoint, which answers every feed error with a 500.
+// Bad requests, including cursors the feed can't continue from, get an
+// InvalidRequest error and unknown feeds an UnknownFeed error, as the
+// lexicon specifies, so clients can tell them from server failures.
+func getFeedSkeleton(router *feedrouter.FeedRouter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Set by the auth middleware.
+		userDID := c.GetString("user_did")
+
+		feedQuery := c.Query("feed")
+		if feedQuery == "" {
+			c.JSON(http.StatusBadRequest, xrpcError{"InvalidRequest", "feed query parameter is required"})
+			return
+		}
+		var feedName string
+		for _, prefix := range router.AcceptableURIPrefixes {
+			if name, ok := strings.CutPrefix(feedQuery, prefix); ok {
+				feedName = name
+				break
+			}
+		}
+		feed, ok := router.FeedMap[feedName]
+		if !ok {
+			c.JSON(http.StatusBadRequest, xrpcError{"UnknownFeed", "this feed generator does not serve " + feedQuery})
+			return
+		}
+
+		limit := int64(defaultPageLimit)
+		if limitQuery := c.Query("limit"); limitQuery != "" {
+			parsed, err := strconv.ParseInt(limitQuery, 10, 64)
+			if err != nil || parsed < 1 {
+				c.JSON(http.StatusBadRequest, xrpcError{"InvalidRequest", "limit must be a positive integer"})
+				return
+			}
+			limit = min(parsed, maxPageLimit)
+		}
+
+		posts, cursor, err := feed.GetPage(c.Request.Context(), feedName, userDID, limit, c.Query("cursor"))
+		if errors.Is(err, ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, xrpcError{"InvalidRequest", err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, xrpcError{"InternalServerError", "failed to get feed items"})
+			return
+		}
+		c.JSON(http.StatusOK, bsky.FeedGetFeedSkeleton_Output{
+			Feed:   posts,
+			Cursor: cursor,
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+	"jetstream-feed-generator/store"
+)
+
+// graphPrefix is the start of the keys of follow and block records in a
+// repo, which sorts them next to each other.
+const graphPrefix = "app.bsky.graph."
+
+// errPastGraph stops the walk of a repo once it is past the graph records.
+// The walk wraps the errors it returns on the way up the tree, so it is
+// matched with errors.Is rather than repo.ErrDoneIterating.
+var errPastGraph = errors.New("past graph records")
+
+// BootstrapResult summarizes the edges loaded from a repo export.
+type BootstrapResult struct {
+	Did     string
+	Follows int
+	Blocks  int
+	// Skipped counts records whose subject isn't a valid DID.
+	Skipped int
+}
+
+// Bootstrap replaces the follows and blocks recorded for an account with
+// those in an export of its repo, as a CAR file (from
+// com.atproto.sync.getRepo), so its network is known without waiting for the
+// consumer to see each record.
+//
+// The export isn't verified, so it must come from a trusted source, and it
+// should be fresh: records deleted after it was taken are only removed again
+// once the consumer sees their deletes, which it may have seen already.
+func (g *Graph) Bootstrap(ctx context.Context, car io.Reader) (BootstrapResult, error) {
+	r, err := repo.ReadRepoFromCar(ctx, car)
+	if err != nil {
+		return BootstrapResult{}, fmt.Errorf("failed to read repo: %w", err)
+	}
+	result := BootstrapResult{Did: r.RepoDid()}
+	if _, err := syntax.ParseDID(result.Did); err != nil {
+		return result, fmt.Errorf("repo has an invalid DID: %w", err)
+	}
+
+	var edges []store.GraphEdge
+	err = r.ForEach(ctx, graphPrefix, func(key string, _ cid.Cid) error {
+		if !strings.HasPrefix(key, graphPrefix) {
+			return errPastGraph
+		}
+		collection, rkey, _ := strings.Cut(key, "/")
+		if collection != store.FollowCollection && collection != store.BlockCollection {
+			return nil
+		}
+		_, record, err := r.GetRecord(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		var subject string
+		switch record := record.(type) {
+		case *bsky.GraphFollow:
+			subject = record.Subject
+		case *bsky.GraphBlock:
+			subject = record.Subject
+		default:
+			return fmt.Errorf("unexpected %T record at %s", record, key)
+		}
+		if _, err := syntax.ParseDID(subject); err != nil {
+			result.Skipped++
+			return nil
+		}
+		edges = append(edges, store.GraphEdge{
+			Did:        result.Did,
+			Collection: collection,
+			Rkey:       rkey,
+			SubjectDid: subject,
+		})
+		if collection == store.FollowCollection {
+			result.Follows++
+		} else {
+			result.Blocks++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPastGraph) {
+		return result, fmt.Errorf("failed to walk repo: %w", err)
+	}
+
+	err = g.store.InTx(ctx, func(w store.Writer) error {
+		if err := w.DeleteActorGraphEdges(ctx, result.Did); err != nil {
+			return fmt.Errorf("failed to delete graph edges: %w", err)
+		}
+		for _, edge := range edges {
+			if err := w.AddGraphEdge(ctx, edge); err != nil {
+				return fmt.Errorf("failed to add graph edge: %w", err)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,146 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	"jetstream-feed-generator/store"
+	"jetstream-feed-generator/store/sqlite"
+)
+
+const testDid = "did:plc:bootstrap"
+
+// exportRepo builds a repo for testDid with the given number of follows and
+// blocks, surrounded by other records, and exports it as a CAR file.
+func exportRepo(t *testing.T, follows, blocks int) []byte {
+	t.Helper()
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	r := repo.NewRepo(ctx, testDid, bs)
+
+	// Enough records on either side of the graph collections that the
+	// tree has several levels, so the walk passes through subtrees both
+	// before and after the graph records.
+	put := func(collection string, n int, record func(i int) repo.CborMarshaler) {
+		for i := range n {
+			if _, err := r.PutRecord(ctx, fmt.Sprintf("%s/%06d", collection, i), record(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	put("app.bsky.feed.post", 200, func(i int) repo.CborMarshaler {
+		return &bsky.FeedPost{Text: fmt.Sprint("post ", i), CreatedAt: "2024-01-01T00:00:00Z"}
+	})
+	put(store.FollowCollection, follows, func(i int) repo.CborMarshaler {
+		return &bsky.GraphFollow{Subject: fmt.Sprintf("did:plc:followed%d", i), CreatedAt: "2024-01-01T00:00:00Z"}
+	})
+	put(store.BlockCollection, blocks, func(i int) repo.CborMarshaler {
+		return &bsky.GraphBlock{Subject: fmt.Sprintf("did:plc:blocked%d", i), CreatedAt: "2024-01-01T00:00:00Z"}
+	})
+	put("app.bsky.graph.listitem", 50, func(i int) repo.CborMarshaler {
+		return &bsky.GraphListitem{Subject: fmt.Sprintf("did:plc:listed%d", i), List: "at://did:plc:bootstrap/app.bsky.graph.list/1", CreatedAt: "2024-01-01T00:00:00Z"}
+	})
+	put("app.bsky.labeler.service", 200, func(i int) repo.CborMarshaler {
+		return &bsky.LabelerService{CreatedAt: "2024-01-01T00:00:00Z", Policies: &bsky.LabelerDefs_LabelerPolicies{}}
+	})
+	root, _, err := r.Commit(ctx, func(context.Context, string, []byte) ([]byte, error) {
+		return []byte("signature"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	car, err := carstorage.NewWritable(&buf, []cid.Cid{root}, carv2.WriteAsCarV1(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range keys {
+		block, err := bs.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := car.Put(ctx, key.KeyString(), block.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := car.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBootstrap(t *testing.T) {
+	ctx := context.Background()
+	st, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	if _, err := st.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// An edge missing from the export is replaced.
+	err = st.InTx(ctx, func(w store.Writer) error {
+		return w.AddGraphEdge(ctx, store.GraphEdge{Did: testDid, Collection: store.FollowCollection, Rkey: "old", SubjectDid: "did:plc:unfollowed"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := New(st)
+	result, err := g.Bootstrap(ctx, bytes.NewReader(exportRepo(t, 150, 40)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BootstrapResult{Did: testDid, Follows: 150, Blocks: 40}
+	if result != want {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		list func(ctx context.Context, did, after string, limit int) ([]string, error)
+		want int
+	}{
+		{"follows", g.Follows, 150},
+		{"blocks", g.Blocks, 40},
+	} {
+		subjects, err := tt.list(ctx, testDid, "", 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(subjects) != tt.want {
+			t.Errorf("%d %s, want %d", len(subjects), tt.name, tt.want)
+		}
+	}
+	following, err := g.Relationship(ctx, testDid, "did:plc:unfollowed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if following.Following {
+		t.Error("follow missing from the export wasn't removed")
+	}
+
+	// Edges of other collections aren't stored as blocks.
+	err = st.InTx(ctx, func(w store.Writer) error {
+		return w.AddGraphEdge(ctx, store.GraphEdge{Did: testDid, Collection: "app.bsky.graph.listitem", Rkey: "1", SubjectDid: "did:plc:listed"})
+	})
+	if err == nil {
+		t.Error("added a graph edge for app.bsky.graph.listitem")
+	}
+}
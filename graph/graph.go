@@ -0,0 +1,84 @@
+// Package graph reads the follows and blocks recorded by the consumer, for
+// feeds built around an account's network, and bootstraps them from repo
+// exports.
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"jetstream-feed-generator/store"
+)
+
+// Graph answers questions about follows and blocks. It only knows the edges
+// the consumer has seen, with CONSUMER_GRAPH enabled, and those loaded by
+// Bootstrap.
+type Graph struct {
+	store store.Store
+}
+
+func New(st store.Store) *Graph {
+	return &Graph{store: st}
+}
+
+// Follows returns up to limit DIDs of the accounts did follows, continuing
+// from the DID after, which is "" for the first page and the last DID
+// returned for the next.
+func (g *Graph) Follows(ctx context.Context, did, after string, limit int) ([]string, error) {
+	return g.store.ListGraphSubjects(ctx, did, store.FollowCollection, after, limit)
+}
+
+// Followers pages through the accounts that follow did, like Follows.
+func (g *Graph) Followers(ctx context.Context, did, after string, limit int) ([]string, error) {
+	return g.store.ListGraphActors(ctx, did, store.FollowCollection, after, limit)
+}
+
+// Blocks pages through the accounts did has blocked, like Follows.
+func (g *Graph) Blocks(ctx context.Context, did, after string, limit int) ([]string, error) {
+	return g.store.ListGraphSubjects(ctx, did, store.BlockCollection, after, limit)
+}
+
+// BlockedBy pages through the accounts that have blocked did, like Follows.
+func (g *Graph) BlockedBy(ctx context.Context, did, after string, limit int) ([]string, error) {
+	return g.store.ListGraphActors(ctx, did, store.BlockCollection, after, limit)
+}
+
+// Relationship is how one account relates to another.
+type Relationship struct {
+	Following  bool
+	FollowedBy bool
+	Blocking   bool
+	BlockedBy  bool
+}
+
+// Blocked reports whether either account has blocked the other.
+func (r Relationship) Blocked() bool {
+	return r.Blocking || r.BlockedBy
+}
+
+// Relationship returns how did relates to other.
+func (g *Graph) Relationship(ctx context.Context, did, other string) (Relationship, error) {
+	edges, err := g.store.GraphEdgesBetween(ctx, did, other)
+	if err != nil {
+		return Relationship{}, fmt.Errorf("failed to get graph edges: %w", err)
+	}
+	var rel Relationship
+	for _, edge := range edges {
+		outgoing := edge.Did == did
+		switch edge.Collection {
+		case store.FollowCollection:
+			if outgoing {
+				rel.Following = true
+			} else {
+				rel.FollowedBy = true
+			}
+		case store.BlockCollection:
+			if outgoing {
+				rel.Blocking = true
+			} else {
+				rel.BlockedBy = true
+			}
+		}
+	}
+	return rel, nil
+}
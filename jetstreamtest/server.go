@@ -0,0 +1,237 @@
+// Package jetstreamtest provides an in-process server speaking the Jetstream
+// subscribe protocol, so the consumer can be run end to end without a
+// network connection.
+//
+//	srv := jetstreamtest.NewServer()
+//	defer srv.Close()
+//	srv.AddEvents(events...)
+//	go consumer.RunConsumer(ctx, consumer.Config{JetstreamURLs: []string{srv.URL}, ...})
+package jetstreamtest
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Subscription records the parameters of one subscribe request.
+type Subscription struct {
+	// Cursor is nil if the client didn't send one.
+	Cursor            *int64
+	WantedCollections []string
+	WantedDids        []string
+	Compress          bool
+}
+
+// Server serves scripted events at URL. Like Jetstream, it replays events
+// from the requested cursor onwards, or only sends events added after the
+// client connected if there is no cursor, and then streams events as they
+// are added.
+type Server struct {
+	// URL is the websocket URL to subscribe to.
+	URL string
+
+	http     *httptest.Server
+	upgrader websocket.Upgrader
+	encoder  *zstd.Encoder
+
+	mu            sync.Mutex
+	events        []*models.Event
+	added         chan struct{}
+	conns         map[*websocket.Conn]bool
+	subscriptions []Subscription
+	closed        bool
+}
+
+func NewServer() *Server {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(models.ZSTDDictionary))
+	if err != nil {
+		panic("jetstreamtest: failed to create zstd encoder: " + err.Error())
+	}
+	s := &Server{
+		encoder: encoder,
+		added:   make(chan struct{}),
+		conns:   make(map[*websocket.Conn]bool),
+	}
+	s.http = httptest.NewServer(http.HandlerFunc(s.handleSubscribe))
+	s.URL = "ws" + strings.TrimPrefix(s.http.URL, "http") + "/subscribe"
+	return s
+}
+
+// AddEvents appends events to the stream and sends them to connected
+// clients that want them. Events must be added in time order; an event with
+// no TimeUS is stamped with the current time, or just after the previous
+// event if that is later.
+func (s *Server) AddEvents(events ...*models.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range events {
+		if event.TimeUS == 0 {
+			event.TimeUS = time.Now().UnixMicro()
+			if n := len(s.events); n > 0 && event.TimeUS <= s.events[n-1].TimeUS {
+				event.TimeUS = s.events[n-1].TimeUS + 1
+			}
+		}
+		s.events = append(s.events, event)
+	}
+	close(s.added)
+	s.added = make(chan struct{})
+}
+
+// DropConnections closes every open connection without a close frame, as if
+// the network had failed. Clients may reconnect.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.NetConn().Close()
+	}
+}
+
+// Subscriptions returns the subscribe requests received so far, oldest
+// first.
+func (s *Server) Subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.subscriptions)
+}
+
+// Connected returns the number of open connections.
+func (s *Server) Connected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Close drops every connection and shuts the server down.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.DropConnections()
+	s.http.Close()
+	s.encoder.Close()
+}
+
+func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/subscribe" {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	sub := Subscription{
+		WantedCollections: query["wantedCollections"],
+		WantedDids:        query["wantedDids"],
+		Compress:          query.Get("compress") == "true" || r.Header.Get("Socket-Encoding") == "zstd",
+	}
+	if cursorParam := query.Get("cursor"); cursorParam != "" {
+		cursor, err := strconv.ParseInt(cursorParam, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		sub.Cursor = &cursor
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.conns[conn] = true
+	s.subscriptions = append(s.subscriptions, sub)
+	next := len(s.events)
+	if sub.Cursor != nil {
+		next, _ = slices.BinarySearchFunc(s.events, *sub.Cursor, func(e *models.Event, cursor int64) int {
+			return cmp.Compare(e.TimeUS, cursor)
+		})
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	// Clients don't send anything, but reading notices when they go away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		s.mu.Lock()
+		pending := s.events[next:]
+		added := s.added
+		s.mu.Unlock()
+
+		for _, event := range pending {
+			next++
+			if !sub.wants(event) {
+				continue
+			}
+			if err := s.send(conn, sub, event); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-gone:
+			return
+		case <-added:
+		}
+	}
+}
+
+func (s *Server) send(conn *websocket.Conn, sub Subscription, event *models.Event) error {
+	msg, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if sub.Compress {
+		return conn.WriteMessage(websocket.BinaryMessage, s.encoder.EncodeAll(msg, nil))
+	}
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// wants applies the subscription's filters. As in Jetstream, collection
+// filters only apply to commits and may end in ".*" to match an NSID prefix.
+func (sub Subscription) wants(event *models.Event) bool {
+	if len(sub.WantedDids) > 0 && !slices.Contains(sub.WantedDids, event.Did) {
+		return false
+	}
+	if event.Commit == nil || len(sub.WantedCollections) == 0 {
+		return true
+	}
+	for _, wanted := range sub.WantedCollections {
+		if prefix, ok := strings.CutSuffix(wanted, ".*"); ok {
+			if strings.HasPrefix(event.Commit.Collection, prefix+".") {
+				return true
+			}
+		} else if event.Commit.Collection == wanted {
+			return true
+		}
+	}
+	return false
+}
@@ -8,7 +8,19 @@ import (
 )
 
 func main() {
-	if err := config.Execute(run); err != nil {
+	commands := config.Commands{
+		Run:                  run,
+		Migrate:              application.Migrate,
+		InspectFeeds:         application.InspectFeeds,
+		InspectPage:          application.InspectPage,
+		Replay:               application.Replay,
+		ListDeadLetters:      application.ListDeadLetters,
+		ReprocessDeadLetters: application.ReprocessDeadLetters,
+		InspectGraph:         application.InspectGraph,
+		InspectRecords:       application.InspectRecords,
+		BootstrapGraph:       application.BootstrapGraph,
+	}
+	if err := config.Execute(commands); err != nil {
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,76 @@
+// Package metrics defines the Prometheus metrics exported by the consumer and
+// the feed generator. The Jetstream client library registers its own
+// jetstream_client_events_read and jetstream_client_bytes_read counters,
+// which are exported alongside these.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "jetstream_feed_generator"
+
+var (
+	ConsumerLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_lag_seconds",
+		Help:      "Age of the newest event up to which every event has been handled, updated every stats interval",
+	})
+
+	ConsumerCursor = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_cursor",
+		Help:      "Jetstream cursor up to which every event has been handled (unix microseconds), updated every stats interval",
+	})
+
+	ConsumerDisconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_disconnects_total",
+		Help:      "Number of failed or dropped Jetstream connections by endpoint",
+	}, []string{"url"})
+
+	HandlerErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_handler_errors_total",
+		Help:      "Number of events the consumer failed to handle",
+	})
+
+	DeadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_dead_letters_total",
+		Help:      "Number of events recorded as dead letters after failing",
+	})
+
+	FeedPostsMatched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_posts_matched_total",
+		Help:      "Number of posts added to or refreshed in a feed",
+	}, []string{"feed"})
+
+	DBWriteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_write_duration_seconds",
+		Help:      "Time taken to commit a batch of writes and cursors",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+
+	FeedPostsPruned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_posts_pruned_total",
+		Help:      "Number of posts deleted from a feed by its retention policy",
+	}, []string{"feed"})
+
+	FeedSkeletonRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_skeleton_requests_total",
+		Help:      "Number of getFeedSkeleton requests by feed and outcome",
+	}, []string{"feed", "status"})
+
+	FeedSkeletonDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "feed_skeleton_request_duration_seconds",
+		Help:      "Time taken to generate a feed page",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"feed"})
+)
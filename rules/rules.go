@@ -0,0 +1,315 @@
+// Package rules builds post matchers from declarative rule definitions, so
+// that feeds can be described in config instead of Go code.
+package rules
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	apibsky "github.com/bluesky-social/indigo/api/bsky"
+)
+
+// Embed types accepted by Rule.EmbedTypes.
+const (
+	EmbedImages          = "images"
+	EmbedVideo           = "video"
+	EmbedExternal        = "external"
+	EmbedRecord          = "record"
+	EmbedRecordWithMedia = "record_with_media"
+)
+
+// Rule describes which posts a feed should contain. Every condition set on a
+// rule must hold for a post to match; list-valued conditions match if any
+// element matches. And, Or and Not combine nested rules.
+type Rule struct {
+	And []Rule `mapstructure:"and"`
+	Or  []Rule `mapstructure:"or"`
+	Not *Rule  `mapstructure:"not"`
+
+	// TextRegex is matched against the post text (Go regexp syntax).
+	TextRegex string `mapstructure:"text_regex"`
+	// Keywords are matched case-insensitively as whole words in the post text.
+	// They must not be empty.
+	Keywords []string `mapstructure:"keywords"`
+	// Hashtags are matched case-insensitively, without the leading '#',
+	// against tag facets and the post's tags field.
+	Hashtags []string `mapstructure:"hashtags"`
+	// Langs are matched against the languages declared on the post.
+	Langs []string `mapstructure:"langs"`
+	// EmbedTypes are matched against the post's embed; see the Embed constants.
+	EmbedTypes []string `mapstructure:"embed_types"`
+	// LinkDomains are matched against the hosts of external embeds and link
+	// facets. Subdomains of a listed domain also match.
+	LinkDomains []string `mapstructure:"link_domains"`
+	// AuthorDIDs, if set, restricts the rule to posts by these accounts.
+	AuthorDIDs []string `mapstructure:"author_dids"`
+	// ExcludeAuthorDIDs rejects posts by these accounts.
+	ExcludeAuthorDIDs []string `mapstructure:"exclude_author_dids"`
+	// Reply, if set, requires the post to be (or not be) a reply.
+	Reply *bool `mapstructure:"reply"`
+	// Quote, if set, requires the post to (or not) quote another record.
+	Quote *bool `mapstructure:"quote"`
+}
+
+// Matcher decides whether a post by the given author belongs in a feed.
+type Matcher interface {
+	Match(did string, post *apibsky.FeedPost) bool
+}
+
+// MatcherFunc adapts a function to the Matcher interface.
+type MatcherFunc func(did string, post *apibsky.FeedPost) bool
+
+func (f MatcherFunc) Match(did string, post *apibsky.FeedPost) bool {
+	return f(did, post)
+}
+
+type all []Matcher
+
+func (ms all) Match(did string, post *apibsky.FeedPost) bool {
+	for _, m := range ms {
+		if !m.Match(did, post) {
+			return false
+		}
+	}
+	return true
+}
+
+type anyOf []Matcher
+
+func (ms anyOf) Match(did string, post *apibsky.FeedPost) bool {
+	for _, m := range ms {
+		if m.Match(did, post) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile validates the rule and returns a Matcher for it.
+func (r Rule) Compile() (Matcher, error) {
+	var ms all
+
+	for i, sub := range r.And {
+		m, err := sub.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("and[%d]: %w", i, err)
+		}
+		ms = append(ms, m)
+	}
+	if len(r.Or) > 0 {
+		var or anyOf
+		for i, sub := range r.Or {
+			m, err := sub.Compile()
+			if err != nil {
+				return nil, fmt.Errorf("or[%d]: %w", i, err)
+			}
+			or = append(or, m)
+		}
+		ms = append(ms, or)
+	}
+	if r.Not != nil {
+		m, err := r.Not.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("not: %w", err)
+		}
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			return !m.Match(did, post)
+		}))
+	}
+
+	if r.TextRegex != "" {
+		re, err := regexp.Compile(r.TextRegex)
+		if err != nil {
+			return nil, fmt.Errorf("text_regex: %w", err)
+		}
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			return re.MatchString(post.Text)
+		}))
+	}
+	if len(r.Keywords) > 0 {
+		patterns := make([]string, len(r.Keywords))
+		for i, kw := range r.Keywords {
+			if strings.TrimSpace(kw) == "" {
+				return nil, fmt.Errorf("keywords[%d]: keyword is empty", i)
+			}
+			patterns[i] = keywordPattern(kw)
+		}
+		re := regexp.MustCompile(`(?i)` + strings.Join(patterns, "|"))
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			return re.MatchString(post.Text)
+		}))
+	}
+	if len(r.Hashtags) > 0 {
+		wanted := make([]string, len(r.Hashtags))
+		for i, tag := range r.Hashtags {
+			wanted[i] = strings.ToLower(strings.TrimPrefix(tag, "#"))
+		}
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			for _, tag := range hashtags(post) {
+				if slices.Contains(wanted, strings.ToLower(tag)) {
+					return true
+				}
+			}
+			return false
+		}))
+	}
+	if len(r.Langs) > 0 {
+		langs := r.Langs
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			for _, lang := range post.Langs {
+				if slices.Contains(langs, lang) {
+					return true
+				}
+			}
+			return false
+		}))
+	}
+	if len(r.EmbedTypes) > 0 {
+		for _, t := range r.EmbedTypes {
+			switch t {
+			case EmbedImages, EmbedVideo, EmbedExternal, EmbedRecord, EmbedRecordWithMedia:
+			default:
+				return nil, fmt.Errorf("embed_types: unknown embed type %q", t)
+			}
+		}
+		types := r.EmbedTypes
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			return slices.Contains(types, embedType(post))
+		}))
+	}
+	if len(r.LinkDomains) > 0 {
+		domains := make([]string, len(r.LinkDomains))
+		for i, d := range r.LinkDomains {
+			domains[i] = strings.ToLower(d)
+		}
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			for _, host := range linkHosts(post) {
+				for _, d := range domains {
+					if host == d || strings.HasSuffix(host, "."+d) {
+						return true
+					}
+				}
+			}
+			return false
+		}))
+	}
+	if len(r.AuthorDIDs) > 0 {
+		dids := r.AuthorDIDs
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			return slices.Contains(dids, did)
+		}))
+	}
+	if len(r.ExcludeAuthorDIDs) > 0 {
+		dids := r.ExcludeAuthorDIDs
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			return !slices.Contains(dids, did)
+		}))
+	}
+	if r.Reply != nil {
+		want := *r.Reply
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			return (post.Reply != nil) == want
+		}))
+	}
+	if r.Quote != nil {
+		want := *r.Quote
+		ms = append(ms, MatcherFunc(func(did string, post *apibsky.FeedPost) bool {
+			t := embedType(post)
+			return (t == EmbedRecord || t == EmbedRecordWithMedia) == want
+		}))
+	}
+
+	if len(ms) == 0 {
+		return nil, fmt.Errorf("rule has no conditions")
+	}
+	if len(ms) == 1 {
+		return ms[0], nil
+	}
+	return ms, nil
+}
+
+// keywordPattern matches kw as a whole word. Go's \b only knows ASCII word
+// characters, so the boundaries are spelled out with Unicode classes, and
+// only where kw starts or ends with a letter or digit: "c++" and "#go" need
+// no boundary on their symbol side. Scripts written without spaces between
+// words, such as Chinese and Japanese, get no boundary either, as any
+// keyword in them would otherwise never match.
+func keywordPattern(kw string) string {
+	first, _ := utf8.DecodeRuneInString(kw)
+	last, _ := utf8.DecodeLastRuneInString(kw)
+	pattern := regexp.QuoteMeta(kw)
+	if needsBoundary(first) {
+		pattern = `(?:^|[^\p{L}\p{N}_])` + pattern
+	}
+	if needsBoundary(last) {
+		pattern += `(?:$|[^\p{L}\p{N}_])`
+	}
+	return `(?:` + pattern + `)`
+}
+
+func needsBoundary(r rune) bool {
+	if r != '_' && !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+		return false
+	}
+	return !unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana,
+		unicode.Thai, unicode.Lao, unicode.Khmer, unicode.Myanmar)
+}
+
+func hashtags(post *apibsky.FeedPost) []string {
+	tags := slices.Clone(post.Tags)
+	for _, facet := range post.Facets {
+		for _, feature := range facet.Features {
+			if feature.RichtextFacet_Tag != nil {
+				tags = append(tags, feature.RichtextFacet_Tag.Tag)
+			}
+		}
+	}
+	return tags
+}
+
+func embedType(post *apibsky.FeedPost) string {
+	if post.Embed == nil {
+		return ""
+	}
+	switch {
+	case post.Embed.EmbedImages != nil:
+		return EmbedImages
+	case post.Embed.EmbedVideo != nil:
+		return EmbedVideo
+	case post.Embed.EmbedExternal != nil:
+		return EmbedExternal
+	case post.Embed.EmbedRecord != nil:
+		return EmbedRecord
+	case post.Embed.EmbedRecordWithMedia != nil:
+		return EmbedRecordWithMedia
+	}
+	return ""
+}
+
+func linkHosts(post *apibsky.FeedPost) []string {
+	var uris []string
+	if post.Embed != nil && post.Embed.EmbedExternal != nil && post.Embed.EmbedExternal.External != nil {
+		uris = append(uris, post.Embed.EmbedExternal.External.Uri)
+	}
+	for _, facet := range post.Facets {
+		for _, feature := range facet.Features {
+			if feature.RichtextFacet_Link != nil {
+				uris = append(uris, feature.RichtextFacet_Link.Uri)
+			}
+		}
+	}
+	hosts := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		hosts = append(hosts, strings.ToLower(u.Hostname()))
+	}
+	return hosts
+}
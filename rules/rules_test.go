@@ -0,0 +1,150 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+
+	apibsky "github.com/bluesky-social/indigo/api/bsky"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func textPost(text string) *apibsky.FeedPost {
+	return &apibsky.FeedPost{Text: text}
+}
+
+func externalPost(uri string) *apibsky.FeedPost {
+	return &apibsky.FeedPost{
+		Embed: &apibsky.FeedPost_Embed{
+			EmbedExternal: &apibsky.EmbedExternal{External: &apibsky.EmbedExternal_External{Uri: uri}},
+		},
+	}
+}
+
+func quotePost() *apibsky.FeedPost {
+	return &apibsky.FeedPost{
+		Embed: &apibsky.FeedPost_Embed{EmbedRecord: &apibsky.EmbedRecord{}},
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		did  string
+		post *apibsky.FeedPost
+		want bool
+	}{
+		{"text regex", Rule{TextRegex: `^hello \d+$`}, "", textPost("hello 42"), true},
+		{"text regex miss", Rule{TextRegex: `^hello \d+$`}, "", textPost("hello there"), false},
+
+		{"keyword", Rule{Keywords: []string{"go"}}, "", textPost("I like Go a lot"), true},
+		{"keyword inside word", Rule{Keywords: []string{"go"}}, "", textPost("going home"), false},
+		{"keyword any of", Rule{Keywords: []string{"rust", "zig"}}, "", textPost("zig!"), true},
+		{"keyword ending in symbols", Rule{Keywords: []string{"c++"}}, "", textPost("writing C++ today"), true},
+		{"keyword ending in symbols inside word", Rule{Keywords: []string{"c++"}}, "", textPost("abc++"), false},
+		{"keyword starting with symbol", Rule{Keywords: []string{"#go"}}, "", textPost("news #go"), true},
+		{"keyword starting with symbol prefix", Rule{Keywords: []string{"#go"}}, "", textPost("news #golang"), false},
+		{"keyword with sharp", Rule{Keywords: []string{"f#"}}, "", textPost("F# is neat"), true},
+		{"keyword non-ASCII", Rule{Keywords: []string{"café"}}, "", textPost("Le CAFÉ est ouvert"), true},
+		{"keyword non-ASCII inside word", Rule{Keywords: []string{"café"}}, "", textPost("cafés"), false},
+		{"keyword non-ASCII neighbour", Rule{Keywords: []string{"na"}}, "", textPost("ñandú na"), true},
+		{"keyword non-ASCII neighbour only", Rule{Keywords: []string{"na"}}, "", textPost("ñandúna"), false},
+		{"keyword CJK", Rule{Keywords: []string{"東京"}}, "", textPost("明日は東京に行きます"), true},
+
+		{"hashtag facet", Rule{Hashtags: []string{"#Go"}}, "", &apibsky.FeedPost{
+			Facets: []*apibsky.RichtextFacet{{Features: []*apibsky.RichtextFacet_Features_Elem{
+				{RichtextFacet_Tag: &apibsky.RichtextFacet_Tag{Tag: "go"}},
+			}}},
+		}, true},
+		{"hashtag tags field", Rule{Hashtags: []string{"go"}}, "", &apibsky.FeedPost{Tags: []string{"GO"}}, true},
+		{"hashtag miss", Rule{Hashtags: []string{"go"}}, "", textPost("#go"), false},
+
+		{"lang", Rule{Langs: []string{"en", "fr"}}, "", &apibsky.FeedPost{Langs: []string{"fr"}}, true},
+		{"lang miss", Rule{Langs: []string{"en"}}, "", &apibsky.FeedPost{Langs: []string{"de"}}, false},
+
+		{"embed type", Rule{EmbedTypes: []string{EmbedExternal}}, "", externalPost("https://example.com"), true},
+		{"embed type miss", Rule{EmbedTypes: []string{EmbedImages}}, "", externalPost("https://example.com"), false},
+		{"embed type none", Rule{EmbedTypes: []string{EmbedImages}}, "", textPost("no embed"), false},
+
+		{"link domain", Rule{LinkDomains: []string{"Example.com"}}, "", externalPost("https://example.com/a"), true},
+		{"link subdomain", Rule{LinkDomains: []string{"example.com"}}, "", externalPost("https://www.example.com/"), true},
+		{"link domain suffix only", Rule{LinkDomains: []string{"example.com"}}, "", externalPost("https://badexample.com/"), false},
+		{"link facet", Rule{LinkDomains: []string{"example.com"}}, "", &apibsky.FeedPost{
+			Facets: []*apibsky.RichtextFacet{{Features: []*apibsky.RichtextFacet_Features_Elem{
+				{RichtextFacet_Link: &apibsky.RichtextFacet_Link{Uri: "https://example.com"}},
+			}}},
+		}, true},
+
+		{"author", Rule{AuthorDIDs: []string{"did:plc:a"}}, "did:plc:a", textPost(""), true},
+		{"author miss", Rule{AuthorDIDs: []string{"did:plc:a"}}, "did:plc:b", textPost(""), false},
+		{"excluded author", Rule{ExcludeAuthorDIDs: []string{"did:plc:a"}}, "did:plc:a", textPost(""), false},
+		{"not excluded author", Rule{ExcludeAuthorDIDs: []string{"did:plc:a"}}, "did:plc:b", textPost(""), true},
+
+		{"reply", Rule{Reply: boolPtr(true)}, "", &apibsky.FeedPost{Reply: &apibsky.FeedPost_ReplyRef{}}, true},
+		{"reply miss", Rule{Reply: boolPtr(true)}, "", textPost(""), false},
+		{"not reply", Rule{Reply: boolPtr(false)}, "", textPost(""), true},
+		{"quote", Rule{Quote: boolPtr(true)}, "", quotePost(), true},
+		{"not quote", Rule{Quote: boolPtr(false)}, "", quotePost(), false},
+
+		{"conditions all hold", Rule{Keywords: []string{"go"}, Langs: []string{"en"}}, "",
+			&apibsky.FeedPost{Text: "go", Langs: []string{"en"}}, true},
+		{"conditions one fails", Rule{Keywords: []string{"go"}, Langs: []string{"en"}}, "",
+			&apibsky.FeedPost{Text: "go", Langs: []string{"de"}}, false},
+		{"and", Rule{And: []Rule{{Keywords: []string{"go"}}, {Keywords: []string{"rust"}}}}, "",
+			textPost("go and rust"), true},
+		{"and one fails", Rule{And: []Rule{{Keywords: []string{"go"}}, {Keywords: []string{"rust"}}}}, "",
+			textPost("just go"), false},
+		{"or", Rule{Or: []Rule{{Keywords: []string{"go"}}, {Keywords: []string{"rust"}}}}, "",
+			textPost("just rust"), true},
+		{"or none", Rule{Or: []Rule{{Keywords: []string{"go"}}, {Keywords: []string{"rust"}}}}, "",
+			textPost("just zig"), false},
+		{"not", Rule{Not: &Rule{Keywords: []string{"go"}}}, "", textPost("just zig"), true},
+		{"not matching", Rule{Not: &Rule{Keywords: []string{"go"}}}, "", textPost("just go"), false},
+		{"nested", Rule{
+			Or:  []Rule{{Keywords: []string{"go"}}, {Hashtags: []string{"golang"}}},
+			Not: &Rule{Reply: boolPtr(true)},
+		}, "", textPost("go is fun"), true},
+		{"nested excluded", Rule{
+			Or:  []Rule{{Keywords: []string{"go"}}, {Hashtags: []string{"golang"}}},
+			Not: &Rule{Reply: boolPtr(true)},
+		}, "", &apibsky.FeedPost{Text: "go is fun", Reply: &apibsky.FeedPost_ReplyRef{}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.rule.Compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Match(tt.did, tt.post); got != tt.want {
+				t.Errorf("Match = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want string
+	}{
+		{"no conditions", Rule{}, "no conditions"},
+		{"empty keyword", Rule{Keywords: []string{"go", ""}}, "keywords[1]"},
+		{"blank keyword", Rule{Keywords: []string{"  "}}, "keywords[0]"},
+		{"bad regex", Rule{TextRegex: "("}, "text_regex"},
+		{"unknown embed type", Rule{EmbedTypes: []string{"gif"}}, "unknown embed type"},
+		{"nested error", Rule{Or: []Rule{{Langs: []string{"en"}}, {}}}, "or[1]"},
+		{"not error", Rule{Not: &Rule{Keywords: []string{""}}}, "not: keywords[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.rule.Compile()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Compile error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
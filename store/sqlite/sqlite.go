@@ -0,0 +1,444 @@
+// Package sqlite implements store.Store on a local SQLite database.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	dbpkg "jetstream-feed-generator/db"
+	db "jetstream-feed-generator/db/sqlc"
+	"jetstream-feed-generator/store"
+
+	_ "modernc.org/sqlite"
+)
+
+type Store struct {
+	db *sql.DB
+	q  *db.Queries
+}
+
+var _ store.Store = (*Store)(nil)
+
+// auto_vacuum only takes effect on new databases; existing ones need a
+// one-off "pragma auto_vacuum = incremental; vacuum;" before Maintain can
+// shrink them. busy_timeout lets the pruner and the consumer's batches wait
+// for each other instead of failing.
+const pragmas = "&_pragma=auto_vacuum(incremental)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+
+func Open(filename string) (*Store, error) {
+	sqlDB, err := sql.Open("sqlite", "file:"+filename+"?cache=shared"+pragmas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	return &Store{db: sqlDB, q: db.New(sqlDB)}, nil
+}
+
+func (s *Store) Migrate(ctx context.Context) ([]dbpkg.Migration, error) {
+	return dbpkg.NewMigrator(s.db, dbpkg.SQLite).Apply(ctx)
+}
+
+func (s *Store) PendingMigrations(ctx context.Context) ([]dbpkg.Migration, error) {
+	return dbpkg.NewMigrator(s.db, dbpkg.SQLite).Pending(ctx)
+}
+
+func (s *Store) UpsertFeed(ctx context.Context, feedName string) error {
+	return s.q.UpsertFeed(ctx, feedName)
+}
+
+func (s *Store) FeedCursor(ctx context.Context, feedName string) (int64, error) {
+	feed, err := s.q.GetFeed(ctx, feedName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if feed.LatestCursor.Valid {
+		return feed.LatestCursor.Int64, nil
+	}
+	return 0, nil
+}
+
+func (s *Store) ListFeeds(ctx context.Context) ([]store.FeedInfo, error) {
+	rows, err := s.q.ListFeeds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	feeds := make([]store.FeedInfo, len(rows))
+	for i, row := range rows {
+		feeds[i] = store.FeedInfo{
+			Name:   row.FeedName,
+			Cursor: row.LatestCursor.Int64,
+			Posts:  row.PostCount,
+		}
+	}
+	return feeds, nil
+}
+
+func (s *Store) GetFeedPosts(ctx context.Context, arg store.GetFeedPostsParams) ([]store.FeedPost, error) {
+	var rows []db.FeedPost
+	var err error
+	if arg.FollowingOnly {
+		rows, err = s.q.GetFollowingFeedPosts(ctx, db.GetFollowingFeedPostsParams{
+			FeedName:    arg.FeedName,
+			Viewer:      arg.Viewer,
+			Before:      arg.Before,
+			BeforeDid:   arg.BeforeDid,
+			BeforeRkey:  arg.BeforeRkey,
+			After:       arg.After,
+			HideBlocked: arg.HideBlocked,
+			Limit:       arg.Limit,
+		})
+	} else {
+		rows, err = s.q.GetFeedPosts(ctx, db.GetFeedPostsParams{
+			FeedName:    arg.FeedName,
+			Before:      arg.Before,
+			BeforeDid:   arg.BeforeDid,
+			BeforeRkey:  arg.BeforeRkey,
+			After:       arg.After,
+			HideBlocked: arg.HideBlocked,
+			Viewer:      arg.Viewer,
+			Limit:       arg.Limit,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return feedPosts(rows), nil
+}
+
+func (s *Store) GetTopFeedPosts(ctx context.Context, arg store.GetTopFeedPostsParams) ([]store.FeedPost, error) {
+	var rows []db.FeedPost
+	var err error
+	if arg.FollowingOnly {
+		rows, err = s.q.GetFollowingTopFeedPosts(ctx, db.GetFollowingTopFeedPostsParams{
+			FeedName:    arg.FeedName,
+			Viewer:      arg.Viewer,
+			AsOf:        arg.AsOf,
+			After:       arg.After,
+			HideBlocked: arg.HideBlocked,
+			Limit:       arg.Limit,
+		})
+	} else {
+		rows, err = s.q.GetTopFeedPosts(ctx, db.GetTopFeedPostsParams{
+			FeedName:    arg.FeedName,
+			AsOf:        arg.AsOf,
+			After:       arg.After,
+			HideBlocked: arg.HideBlocked,
+			Viewer:      arg.Viewer,
+			Limit:       arg.Limit,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return feedPosts(rows), nil
+}
+
+func feedPosts(rows []db.FeedPost) []store.FeedPost {
+	posts := make([]store.FeedPost, len(rows))
+	for i, row := range rows {
+		posts[i] = store.FeedPost(row)
+	}
+	return posts
+}
+
+func (s *Store) FeedPostCutoff(ctx context.Context, feedName string, keep int64) (int64, error) {
+	if keep <= 0 {
+		return 0, nil
+	}
+	cutoff, err := s.q.GetFeedPostCutoff(ctx, db.GetFeedPostCutoffParams{
+		FeedName: feedName,
+		Offset:   keep - 1,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return cutoff, err
+}
+
+func (s *Store) PruneFeedPosts(ctx context.Context, feedName string, before int64, limit int) (int64, error) {
+	return s.q.PruneFeedPosts(ctx, db.PruneFeedPostsParams{
+		FeedName: feedName,
+		TimeUs:   before,
+		Limit:    int64(limit),
+	})
+}
+
+func (s *Store) PruneEngagements(ctx context.Context, limit int) (int64, error) {
+	return s.q.PruneEngagements(ctx, int64(limit))
+}
+
+func (s *Store) ListGraphSubjects(ctx context.Context, did, collection, after string, limit int) ([]string, error) {
+	kind, err := graphKind(collection)
+	if err != nil {
+		return nil, err
+	}
+	return s.q.ListGraphSubjects(ctx, db.ListGraphSubjectsParams{
+		Did:   did,
+		Kind:  kind,
+		After: after,
+		Limit: int64(limit),
+	})
+}
+
+func (s *Store) ListGraphActors(ctx context.Context, subjectDid, collection, after string, limit int) ([]string, error) {
+	kind, err := graphKind(collection)
+	if err != nil {
+		return nil, err
+	}
+	return s.q.ListGraphActors(ctx, db.ListGraphActorsParams{
+		SubjectDid: subjectDid,
+		Kind:       kind,
+		After:      after,
+		Limit:      int64(limit),
+	})
+}
+
+func (s *Store) GraphEdgesBetween(ctx context.Context, did, otherDid string) ([]store.GraphEdge, error) {
+	rows, err := s.q.ListGraphEdgesBetween(ctx, db.ListGraphEdgesBetweenParams{
+		Did:      did,
+		OtherDid: otherDid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]store.GraphEdge, len(rows))
+	for i, row := range rows {
+		edges[i] = store.GraphEdge{
+			Did:        row.Did,
+			Collection: graphCollection(row.Kind),
+			Rkey:       row.Rkey,
+			SubjectDid: row.SubjectDid,
+		}
+	}
+	return edges, nil
+}
+
+// Maintain returns free pages to the filesystem and truncates the WAL.
+func (s *Store) Maintain(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, "pragma incremental_vacuum"); err != nil {
+		return fmt.Errorf("incremental vacuum failed: %w", err)
+	}
+	var busy, logPages, checkpointed int
+	err := s.db.QueryRowContext(ctx, "pragma wal_checkpoint(truncate)").Scan(&busy, &logPages, &checkpointed)
+	if err != nil {
+		return fmt.Errorf("WAL checkpoint failed: %w", err)
+	}
+	if busy != 0 {
+		return fmt.Errorf("WAL checkpoint did not complete: database busy")
+	}
+	return nil
+}
+
+func (s *Store) ListDeadLetters(ctx context.Context, afterID int64, limit int) ([]store.DeadLetter, error) {
+	rows, err := s.q.ListDeadLetters(ctx, db.ListDeadLettersParams{
+		ID:    afterID,
+		Limit: int64(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]store.DeadLetter, len(rows))
+	for i, row := range rows {
+		letters[i] = store.DeadLetter{
+			ID:       row.ID,
+			TimeUs:   row.TimeUs,
+			Did:      row.Did,
+			Event:    []byte(row.Event),
+			Error:    row.Error,
+			Attempts: row.Attempts,
+			FailedAt: time.Unix(row.FailedAt, 0),
+		}
+	}
+	return letters, nil
+}
+
+func (s *Store) InTx(ctx context.Context, fn func(store.Writer) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := fn(writer{q: s.q.WithTx(tx)}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+type writer struct {
+	q *db.Queries
+}
+
+func (w writer) UpsertFeedPost(ctx context.Context, post store.FeedPost) error {
+	return w.q.UpsertFeedPost(ctx, db.UpsertFeedPostParams{
+		FeedName: post.FeedName,
+		TimeUs:   post.TimeUs,
+		Did:      post.Did,
+		Rkey:     post.Rkey,
+	})
+}
+
+func (w writer) DeleteFeedPost(ctx context.Context, feedName, did, rkey string) error {
+	return w.q.DeleteFeedPost(ctx, db.DeleteFeedPostParams{
+		FeedName: feedName,
+		Did:      did,
+		Rkey:     rkey,
+	})
+}
+
+func (w writer) UpsertAccount(ctx context.Context, account store.Account) error {
+	return w.q.UpsertAccount(ctx, db.UpsertAccountParams{
+		Did:    account.Did,
+		Active: account.Active,
+		Status: sql.NullString{String: account.Status, Valid: account.Status != ""},
+		TimeUs: account.TimeUs,
+	})
+}
+
+func (w writer) DeleteAccountPosts(ctx context.Context, did string) error {
+	return w.q.DeleteAccountPosts(ctx, did)
+}
+
+func (w writer) UpdateFeedCursor(ctx context.Context, feedName string, cursor int64) error {
+	return w.q.UpdateFeedCursor(ctx, db.UpdateFeedCursorParams{
+		LatestCursor: sql.NullInt64{Int64: cursor, Valid: true},
+		FeedName:     feedName,
+	})
+}
+
+func (w writer) InsertDeadLetter(ctx context.Context, letter store.DeadLetter) error {
+	return w.q.InsertDeadLetter(ctx, db.InsertDeadLetterParams{
+		TimeUs:   letter.TimeUs,
+		Did:      letter.Did,
+		Event:    string(letter.Event),
+		Error:    letter.Error,
+		Attempts: letter.Attempts,
+		FailedAt: letter.FailedAt.Unix(),
+	})
+}
+
+func (w writer) DeleteDeadLetter(ctx context.Context, id int64) error {
+	return w.q.DeleteDeadLetter(ctx, id)
+}
+
+func (w writer) UpdateDeadLetterFailure(ctx context.Context, id int64, errorText string, failedAt time.Time) error {
+	return w.q.UpdateDeadLetterFailure(ctx, db.UpdateDeadLetterFailureParams{
+		Error:    errorText,
+		FailedAt: failedAt.Unix(),
+		ID:       id,
+	})
+}
+
+func (w writer) AddEngagement(ctx context.Context, engagement store.Engagement) error {
+	feeds, err := w.q.CountPostFeeds(ctx, db.CountPostFeedsParams{
+		Did:  engagement.SubjectDid,
+		Rkey: engagement.SubjectRkey,
+	})
+	if err != nil || feeds == 0 {
+		return err
+	}
+	added, err := w.q.InsertEngagement(ctx, db.InsertEngagementParams(engagement))
+	if err != nil || added == 0 {
+		return err
+	}
+	return w.q.UpdatePostEngagement(ctx, engagementDelta(engagement.Collection, engagement.SubjectDid, engagement.SubjectRkey, 1))
+}
+
+func (w writer) DeleteEngagement(ctx context.Context, did, collection, rkey string) error {
+	subject, err := w.q.DeleteEngagement(ctx, db.DeleteEngagementParams{
+		Did:        did,
+		Collection: collection,
+		Rkey:       rkey,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return w.q.UpdatePostEngagement(ctx, engagementDelta(collection, subject.SubjectDid, subject.SubjectRkey, -1))
+}
+
+func (w writer) AddGraphEdge(ctx context.Context, edge store.GraphEdge) error {
+	kind, err := graphKind(edge.Collection)
+	if err != nil {
+		return err
+	}
+	for _, did := range []string{edge.Did, edge.SubjectDid} {
+		if err := w.q.InsertActor(ctx, did); err != nil {
+			return err
+		}
+	}
+	return w.q.InsertGraphEdge(ctx, db.InsertGraphEdgeParams{
+		Kind:       kind,
+		Rkey:       edge.Rkey,
+		Did:        edge.Did,
+		SubjectDid: edge.SubjectDid,
+	})
+}
+
+func (w writer) DeleteGraphEdge(ctx context.Context, did, collection, rkey string) error {
+	kind, err := graphKind(collection)
+	if err != nil {
+		return err
+	}
+	return w.q.DeleteGraphEdge(ctx, db.DeleteGraphEdgeParams{
+		Did:  did,
+		Kind: kind,
+		Rkey: rkey,
+	})
+}
+
+func (w writer) DeleteActorGraphEdges(ctx context.Context, did string) error {
+	return w.q.DeleteActorGraphEdges(ctx, did)
+}
+
+// engagementDelta adds n to the post's like or repost count.
+func engagementDelta(collection, did, rkey string, n int64) db.UpdatePostEngagementParams {
+	arg := db.UpdatePostEngagementParams{Did: did, Rkey: rkey}
+	switch collection {
+	case store.LikeCollection:
+		arg.LikeDelta = n
+	case store.RepostCollection:
+		arg.RepostDelta = n
+	}
+	return arg
+}
+
+// Graph edges are stored with a small kind in place of their collection.
+const (
+	followKind int64 = 1
+	blockKind  int64 = 2
+)
+
+func graphKind(collection string) (int64, error) {
+	switch collection {
+	case store.FollowCollection:
+		return followKind, nil
+	case store.BlockCollection:
+		return blockKind, nil
+	}
+	return 0, fmt.Errorf("unsupported graph collection %q", collection)
+}
+
+func graphCollection(kind int64) string {
+	if kind == followKind {
+		return store.FollowCollection
+	}
+	return store.BlockCollection
+}
@@ -0,0 +1,200 @@
+// Package store defines the storage interface shared by the consumer and the
+// feed generator. Implementations live in the sqlite and postgres
+// subpackages; with PostgreSQL the two halves can run as separate processes
+// on different hosts against a shared database.
+package store
+
+import (
+	"context"
+	"time"
+
+	"jetstream-feed-generator/db"
+)
+
+// FeedPost is a post stored in a feed.
+type FeedPost struct {
+	FeedName string
+	TimeUs   int64
+	Did      string
+	Rkey     string
+	// LikeCount and RepostCount count the likes and reposts seen while the
+	// post was in some feed. PeakEngagement is the most their sum has been,
+	// which unlike the counts never goes down.
+	LikeCount      int64
+	RepostCount    int64
+	PeakEngagement int64
+}
+
+// Collections of the records counted as engagement with a post.
+const (
+	LikeCollection   = "app.bsky.feed.like"
+	RepostCollection = "app.bsky.feed.repost"
+)
+
+// Collections of the records that make up the social graph.
+const (
+	FollowCollection = "app.bsky.graph.follow"
+	BlockCollection  = "app.bsky.graph.block"
+)
+
+// GraphEdge is a follow or block record, identified by its author,
+// collection and record key, of the account SubjectDid.
+type GraphEdge struct {
+	Did        string
+	Collection string
+	Rkey       string
+	SubjectDid string
+}
+
+// Engagement is a like or repost record, identified by its author,
+// collection and record key, of the post SubjectDid/SubjectRkey.
+type Engagement struct {
+	Did         string
+	Collection  string
+	Rkey        string
+	SubjectDid  string
+	SubjectRkey string
+}
+
+// Account is the latest known status of an account.
+type Account struct {
+	Did    string
+	Active bool
+	// Status is the reason an inactive account is inactive, e.g.
+	// "deactivated", "takendown" or "deleted".
+	Status string
+	TimeUs int64
+}
+
+// DeadLetter is an event the consumer failed to handle.
+type DeadLetter struct {
+	ID     int64
+	TimeUs int64
+	Did    string
+	// Event is the event as JSON.
+	Event    []byte
+	Error    string
+	Attempts int64
+	FailedAt time.Time
+}
+
+// FeedInfo summarizes a registered feed.
+type FeedInfo struct {
+	Name string
+	// Cursor is the feed's saved cursor, or 0 if it has none.
+	Cursor int64
+	Posts  int64
+}
+
+type GetFeedPostsParams struct {
+	FeedName string
+	// Before, BeforeDid and BeforeRkey are an exclusive upper bound on
+	// (TimeUs, Did, Rkey); with an empty BeforeDid, every post at Before is
+	// excluded. After is an exclusive lower bound on TimeUs and may be 0.
+	Before     int64
+	BeforeDid  string
+	BeforeRkey string
+	After      int64
+	// Viewer is the DID of the account the posts are for. With HideBlocked,
+	// posts by accounts that have blocked the viewer or that the viewer has
+	// blocked are left out; with FollowingOnly, only posts by accounts the
+	// viewer follows are returned.
+	Viewer        string
+	HideBlocked   bool
+	FollowingOnly bool
+	Limit         int64
+}
+
+type GetTopFeedPostsParams struct {
+	FeedName string
+	// AsOf is an inclusive upper bound and After an exclusive lower bound
+	// on TimeUs; After may be 0.
+	AsOf  int64
+	After int64
+	// Viewer, HideBlocked and FollowingOnly personalize the posts as for
+	// GetFeedPosts.
+	Viewer        string
+	HideBlocked   bool
+	FollowingOnly bool
+	Limit         int64
+}
+
+// Store is a storage backend for feeds, their cursors and posts.
+type Store interface {
+	// Migrate applies pending schema migrations and returns the ones it
+	// applied.
+	Migrate(ctx context.Context) ([]db.Migration, error)
+	// PendingMigrations returns the migrations Migrate would apply.
+	PendingMigrations(ctx context.Context) ([]db.Migration, error)
+	// UpsertFeed registers a feed so it can save a cursor.
+	UpsertFeed(ctx context.Context, feedName string) error
+	// FeedCursor returns the feed's saved cursor, or 0 if it has none.
+	FeedCursor(ctx context.Context, feedName string) (int64, error)
+	// ListFeeds returns every registered feed, ordered by name.
+	ListFeeds(ctx context.Context) ([]FeedInfo, error)
+	// GetFeedPosts returns a feed's posts, newest first with ties broken by
+	// DID and record key, both descending, leaving out posts from inactive
+	// accounts.
+	GetFeedPosts(ctx context.Context, arg GetFeedPostsParams) ([]FeedPost, error)
+	// GetTopFeedPosts returns a feed's posts with the highest
+	// PeakEngagement first, ties broken newest first, leaving out posts from
+	// inactive accounts.
+	GetTopFeedPosts(ctx context.Context, arg GetTopFeedPostsParams) ([]FeedPost, error)
+	// FeedPostCutoff returns the TimeUs of the feed's keep-th newest post,
+	// or 0 if the feed has fewer posts.
+	FeedPostCutoff(ctx context.Context, feedName string, keep int64) (int64, error)
+	// PruneFeedPosts deletes up to limit of the feed's posts older than
+	// before, each call in its own short transaction, and returns how many
+	// it deleted.
+	PruneFeedPosts(ctx context.Context, feedName string, before int64, limit int) (int64, error)
+	// PruneEngagements deletes up to limit likes and reposts of posts that
+	// are no longer in any feed and returns how many it deleted.
+	PruneEngagements(ctx context.Context, limit int) (int64, error)
+	// ListGraphSubjects returns up to limit DIDs of the accounts did follows
+	// or blocks, as given by collection, continuing from the account after
+	// ("" to start), in an order fixed by the store.
+	ListGraphSubjects(ctx context.Context, did, collection, after string, limit int) ([]string, error)
+	// ListGraphActors is the reverse of ListGraphSubjects: it returns the
+	// DIDs of the accounts that follow or block subjectDid.
+	ListGraphActors(ctx context.Context, subjectDid, collection, after string, limit int) ([]string, error)
+	// GraphEdgesBetween returns the follows and blocks between two accounts,
+	// in either direction.
+	GraphEdgesBetween(ctx context.Context, did, otherDid string) ([]GraphEdge, error)
+	// Maintain reclaims space freed by pruning.
+	Maintain(ctx context.Context) error
+	// ListDeadLetters returns up to limit dead letters with IDs above
+	// afterID, oldest first.
+	ListDeadLetters(ctx context.Context, afterID int64, limit int) ([]DeadLetter, error)
+	// InTx runs fn in a transaction, which is committed if fn returns nil.
+	InTx(ctx context.Context, fn func(Writer) error) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Writer holds the writes made by the consumer. Store.InTx provides one bound
+// to a transaction.
+type Writer interface {
+	UpsertFeedPost(ctx context.Context, post FeedPost) error
+	DeleteFeedPost(ctx context.Context, feedName, did, rkey string) error
+	UpsertAccount(ctx context.Context, account Account) error
+	// DeleteAccountPosts removes an account's posts from every feed.
+	DeleteAccountPosts(ctx context.Context, did string) error
+	UpdateFeedCursor(ctx context.Context, feedName string, cursor int64) error
+	// AddEngagement records a like or repost and counts it, if its subject
+	// is in some feed; others are ignored. Adding the same record again has
+	// no effect.
+	AddEngagement(ctx context.Context, engagement Engagement) error
+	// DeleteEngagement uncounts a like or repost recorded by AddEngagement.
+	DeleteEngagement(ctx context.Context, did, collection, rkey string) error
+	// AddGraphEdge records a follow or block. Adding the same record again
+	// has no effect.
+	AddGraphEdge(ctx context.Context, edge GraphEdge) error
+	DeleteGraphEdge(ctx context.Context, did, collection, rkey string) error
+	// DeleteActorGraphEdges removes every follow and block made by did.
+	DeleteActorGraphEdges(ctx context.Context, did string) error
+	// InsertDeadLetter records a failed event; ID is assigned by the store.
+	InsertDeadLetter(ctx context.Context, letter DeadLetter) error
+	DeleteDeadLetter(ctx context.Context, id int64) error
+	// UpdateDeadLetterFailure records another failed attempt.
+	UpdateDeadLetterFailure(ctx context.Context, id int64, errorText string, failedAt time.Time) error
+}
@@ -0,0 +1,483 @@
+// Package storetest checks that an implementation of store.Store behaves the
+// way the consumer and the feed generator rely on. Each backend runs it from
+// its own tests:
+//
+//	func TestStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store { return openEmpty(t) })
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"jetstream-feed-generator/store"
+)
+
+// Feed is the feed that the checks register and add posts to.
+const Feed = "test-feed"
+
+// Run runs every check, each against a new store from open, which must be
+// empty and not yet migrated.
+func Run(t *testing.T, open func(t *testing.T) store.Store) {
+	t.Run("Migrate", func(t *testing.T) { testMigrate(t, open(t)) })
+	checks := []struct {
+		name  string
+		check func(t *testing.T, st store.Store)
+	}{
+		{"FeedCursors", testFeedCursors},
+		{"FeedPosts", testFeedPosts},
+		{"InTxRollsBack", testInTxRollsBack},
+		{"Accounts", testAccounts},
+		{"Pruning", testPruning},
+		{"Engagement", testEngagement},
+		{"Personalization", testPersonalization},
+	}
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) { c.check(t, migrated(t, open(t))) })
+	}
+}
+
+// migrated applies every migration to st and registers Feed.
+func migrated(t *testing.T, st store.Store) store.Store {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := st.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertFeed(ctx, Feed); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// write runs fn in a transaction that must commit.
+func write(t *testing.T, st store.Store, fn func(ctx context.Context, w store.Writer) error) {
+	t.Helper()
+	ctx := context.Background()
+	if err := st.InTx(ctx, func(w store.Writer) error { return fn(ctx, w) }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addPosts adds posts to Feed.
+func addPosts(t *testing.T, st store.Store, posts ...store.FeedPost) {
+	t.Helper()
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		for _, p := range posts {
+			p.FeedName = Feed
+			if err := w.UpsertFeedPost(ctx, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// keys returns "did/rkey" for each post.
+func keys(posts []store.FeedPost) []string {
+	ks := make([]string, len(posts))
+	for i, p := range posts {
+		ks[i] = p.Did + "/" + p.Rkey
+	}
+	return ks
+}
+
+// newest returns the keys of Feed's posts, newest first, as served.
+func newest(t *testing.T, st store.Store, arg store.GetFeedPostsParams) []string {
+	t.Helper()
+	arg.FeedName = Feed
+	if arg.Before == 0 {
+		arg.Before = 1 << 62
+	}
+	if arg.Limit == 0 {
+		arg.Limit = 100
+	}
+	posts, err := st.GetFeedPosts(context.Background(), arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys(posts)
+}
+
+func check(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Errorf("%s = %q, want %q", what, got, want)
+	}
+}
+
+func testMigrate(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	pending, err := st.PendingMigrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) == 0 {
+		t.Fatal("no pending migrations on an empty database")
+	}
+	applied, err := st.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(pending) {
+		t.Errorf("applied %d of %d pending migrations", len(applied), len(pending))
+	}
+	if pending, err = st.PendingMigrations(ctx); err != nil || len(pending) != 0 {
+		t.Errorf("pending after migrating = %v, %v, want none", pending, err)
+	}
+	if applied, err = st.Migrate(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("migrating again applied %v, %v, want none", applied, err)
+	}
+}
+
+func testFeedCursors(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	for _, name := range []string{Feed, "unknown"} {
+		if cursor, err := st.FeedCursor(ctx, name); err != nil || cursor != 0 {
+			t.Errorf("cursor of %s without a checkpoint = %d, %v, want 0", name, cursor, err)
+		}
+	}
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		return w.UpdateFeedCursor(ctx, Feed, 1000)
+	})
+	// Registering a feed again keeps its cursor.
+	if err := st.UpsertFeed(ctx, Feed); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpsertFeed(ctx, "another-feed"); err != nil {
+		t.Fatal(err)
+	}
+	if cursor, err := st.FeedCursor(ctx, Feed); err != nil || cursor != 1000 {
+		t.Errorf("cursor = %d, %v, want 1000", cursor, err)
+	}
+
+	addPosts(t, st, store.FeedPost{TimeUs: 1, Did: "did:plc:a", Rkey: "1"}, store.FeedPost{TimeUs: 2, Did: "did:plc:a", Rkey: "2"})
+	feeds, err := st.ListFeeds(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []store.FeedInfo{{Name: "another-feed"}, {Name: Feed, Cursor: 1000, Posts: 2}}
+	if !slices.Equal(feeds, want) {
+		t.Errorf("feeds = %+v, want %+v", feeds, want)
+	}
+}
+
+func testFeedPosts(t *testing.T, st store.Store) {
+	addPosts(t, st,
+		store.FeedPost{TimeUs: 10, Did: "did:plc:a", Rkey: "1"},
+		store.FeedPost{TimeUs: 20, Did: "did:plc:a", Rkey: "2"},
+		store.FeedPost{TimeUs: 20, Did: "did:plc:b", Rkey: "1"},
+		store.FeedPost{TimeUs: 20, Did: "did:plc:b", Rkey: "2"},
+		store.FeedPost{TimeUs: 30, Did: "did:plc:c", Rkey: "1"},
+		// Adding a post again moves it rather than duplicating it.
+		store.FeedPost{TimeUs: 5, Did: "did:plc:c", Rkey: "1"},
+	)
+
+	check(t, "posts", newest(t, st, store.GetFeedPostsParams{}),
+		[]string{"did:plc:b/2", "did:plc:b/1", "did:plc:a/2", "did:plc:a/1", "did:plc:c/1"})
+	check(t, "first page", newest(t, st, store.GetFeedPostsParams{Limit: 2}),
+		[]string{"did:plc:b/2", "did:plc:b/1"})
+	check(t, "posts before a key", newest(t, st, store.GetFeedPostsParams{Before: 20, BeforeDid: "did:plc:b", BeforeRkey: "1"}),
+		[]string{"did:plc:a/2", "did:plc:a/1", "did:plc:c/1"})
+	check(t, "posts before a time", newest(t, st, store.GetFeedPostsParams{Before: 20}),
+		[]string{"did:plc:a/1", "did:plc:c/1"})
+	check(t, "posts after a time", newest(t, st, store.GetFeedPostsParams{After: 10}),
+		[]string{"did:plc:b/2", "did:plc:b/1", "did:plc:a/2"})
+
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		if err := w.DeleteFeedPost(ctx, Feed, "did:plc:b", "1"); err != nil {
+			return err
+		}
+		// Deleting a post that isn't in the feed is fine.
+		return w.DeleteFeedPost(ctx, Feed, "did:plc:b", "3")
+	})
+	check(t, "posts after a delete", newest(t, st, store.GetFeedPostsParams{}),
+		[]string{"did:plc:b/2", "did:plc:a/2", "did:plc:a/1", "did:plc:c/1"})
+}
+
+func testInTxRollsBack(t *testing.T, st store.Store) {
+	errRollback := errors.New("roll back")
+	err := st.InTx(context.Background(), func(w store.Writer) error {
+		ctx := context.Background()
+		post := store.FeedPost{FeedName: Feed, TimeUs: 10, Did: "did:plc:a", Rkey: "1"}
+		if err := w.UpsertFeedPost(ctx, post); err != nil {
+			return fmt.Errorf("failed to add post: %w", err)
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("InTx = %v, want %v", err, errRollback)
+	}
+	check(t, "posts", newest(t, st, store.GetFeedPostsParams{}), nil)
+}
+
+func setAccount(t *testing.T, st store.Store, account store.Account) {
+	t.Helper()
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		return w.UpsertAccount(ctx, account)
+	})
+}
+
+// top returns the keys of Feed's posts, most engaged first, as served.
+func top(t *testing.T, st store.Store, arg store.GetTopFeedPostsParams) []string {
+	t.Helper()
+	arg.FeedName = Feed
+	if arg.AsOf == 0 {
+		arg.AsOf = 1 << 62
+	}
+	if arg.Limit == 0 {
+		arg.Limit = 100
+	}
+	posts, err := st.GetTopFeedPosts(context.Background(), arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys(posts)
+}
+
+func testAccounts(t *testing.T, st store.Store) {
+	addPosts(t, st,
+		store.FeedPost{TimeUs: 10, Did: "did:plc:a", Rkey: "1"},
+		store.FeedPost{TimeUs: 20, Did: "did:plc:b", Rkey: "1"},
+	)
+	both := []string{"did:plc:b/1", "did:plc:a/1"}
+	onlyB := []string{"did:plc:b/1"}
+
+	setAccount(t, st, store.Account{Did: "did:plc:a", Active: false, Status: "deactivated", TimeUs: 100})
+	check(t, "posts with a deactivated author", newest(t, st, store.GetFeedPostsParams{}), onlyB)
+	check(t, "top posts with a deactivated author", top(t, st, store.GetTopFeedPostsParams{}), onlyB)
+
+	// An update older than the status already known is ignored.
+	setAccount(t, st, store.Account{Did: "did:plc:a", Active: true, TimeUs: 50})
+	check(t, "posts after a stale update", newest(t, st, store.GetFeedPostsParams{}), onlyB)
+
+	setAccount(t, st, store.Account{Did: "did:plc:a", Active: true, TimeUs: 200})
+	check(t, "posts with a reactivated author", newest(t, st, store.GetFeedPostsParams{}), both)
+
+	if err := st.UpsertFeed(context.Background(), "another-feed"); err != nil {
+		t.Fatal(err)
+	}
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		post := store.FeedPost{FeedName: "another-feed", TimeUs: 30, Did: "did:plc:a", Rkey: "2"}
+		if err := w.UpsertFeedPost(ctx, post); err != nil {
+			return err
+		}
+		return w.DeleteAccountPosts(ctx, "did:plc:a")
+	})
+	check(t, "posts after purging an author", newest(t, st, store.GetFeedPostsParams{}), onlyB)
+	feeds, err := st.ListFeeds(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range feeds {
+		if f.Name == "another-feed" && f.Posts != 0 {
+			t.Errorf("another-feed has %d posts after purging their author, want 0", f.Posts)
+		}
+	}
+}
+
+func like(did, rkey, subjectDid, subjectRkey string) store.Engagement {
+	return store.Engagement{Did: did, Collection: store.LikeCollection, Rkey: rkey, SubjectDid: subjectDid, SubjectRkey: subjectRkey}
+}
+
+// counts returns each of Feed's posts, newest first, with its like and
+// repost counts and peak engagement.
+func counts(t *testing.T, st store.Store) []string {
+	t.Helper()
+	posts, err := st.GetFeedPosts(context.Background(), store.GetFeedPostsParams{FeedName: Feed, Before: 1 << 62, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := make([]string, len(posts))
+	for i, p := range posts {
+		cs[i] = fmt.Sprintf("%s/%s %d/%d/%d", p.Did, p.Rkey, p.LikeCount, p.RepostCount, p.PeakEngagement)
+	}
+	return cs
+}
+
+func testPruning(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	for i := range 5 {
+		addPosts(t, st, store.FeedPost{TimeUs: int64(i+1) * 10, Did: "did:plc:a", Rkey: fmt.Sprint(i + 1)})
+	}
+	if err := st.UpsertFeed(ctx, "another-feed"); err != nil {
+		t.Fatal(err)
+	}
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		post := store.FeedPost{FeedName: "another-feed", TimeUs: 20, Did: "did:plc:a", Rkey: "2"}
+		if err := w.UpsertFeedPost(ctx, post); err != nil {
+			return err
+		}
+		for _, rkey := range []string{"1", "2", "5"} {
+			if err := w.AddEngagement(ctx, like("did:plc:liker", rkey, "did:plc:a", rkey)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for _, tt := range []struct {
+		keep int64
+		want int64
+	}{{2, 40}, {5, 10}, {6, 0}} {
+		if cutoff, err := st.FeedPostCutoff(ctx, Feed, tt.keep); err != nil || cutoff != tt.want {
+			t.Errorf("cutoff keeping %d posts = %d, %v, want %d", tt.keep, cutoff, err, tt.want)
+		}
+	}
+
+	// Pruning deletes in batches of at most limit posts.
+	for _, want := range []int64{2, 1, 0} {
+		if deleted, err := st.PruneFeedPosts(ctx, Feed, 40, 2); err != nil || deleted != want {
+			t.Errorf("pruned %d posts, %v, want %d", deleted, err, want)
+		}
+	}
+	check(t, "posts after pruning", newest(t, st, store.GetFeedPostsParams{}), []string{"did:plc:a/5", "did:plc:a/4"})
+	feeds, err := st.ListFeeds(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range feeds {
+		if f.Name == "another-feed" && f.Posts != 1 {
+			t.Errorf("another-feed has %d posts after pruning %s, want 1", f.Posts, Feed)
+		}
+	}
+
+	// Only the like of the post that has left every feed goes.
+	if deleted, err := st.PruneEngagements(ctx, 10); err != nil || deleted != 1 {
+		t.Errorf("pruned %d engagements, %v, want 1", deleted, err)
+	}
+	if deleted, err := st.PruneEngagements(ctx, 10); err != nil || deleted != 0 {
+		t.Errorf("pruned %d engagements again, %v, want 0", deleted, err)
+	}
+	// A pruned like counts again if its post comes back.
+	addPosts(t, st, store.FeedPost{TimeUs: 10, Did: "did:plc:a", Rkey: "1"})
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		return w.AddEngagement(ctx, like("did:plc:liker", "1", "did:plc:a", "1"))
+	})
+	posts, err := st.GetFeedPosts(ctx, store.GetFeedPostsParams{FeedName: Feed, Before: 1 << 62, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	likes := make([]int64, len(posts))
+	for i, p := range posts {
+		likes[i] = p.LikeCount
+	}
+	if want := []int64{1, 0, 1}; !slices.Equal(likes, want) {
+		t.Errorf("likes of %q = %v, want %v", keys(posts), likes, want)
+	}
+}
+
+func testEngagement(t *testing.T, st store.Store) {
+	addPosts(t, st,
+		store.FeedPost{TimeUs: 10, Did: "did:plc:a", Rkey: "1"},
+		store.FeedPost{TimeUs: 20, Did: "did:plc:b", Rkey: "1"},
+	)
+	repost := store.Engagement{Did: "did:plc:x", Collection: store.RepostCollection, Rkey: "r", SubjectDid: "did:plc:a", SubjectRkey: "1"}
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		for _, e := range []store.Engagement{
+			like("did:plc:x", "l", "did:plc:a", "1"),
+			like("did:plc:y", "l", "did:plc:a", "1"),
+			repost,
+			// Adding a record again doesn't count it twice.
+			like("did:plc:x", "l", "did:plc:a", "1"),
+			repost,
+			// Posts that aren't in any feed aren't counted.
+			like("did:plc:x", "m", "did:plc:c", "1"),
+		} {
+			if err := w.AddEngagement(ctx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	check(t, "counts", counts(t, st), []string{"did:plc:b/1 0/0/0", "did:plc:a/1 2/1/3"})
+	check(t, "top posts", top(t, st, store.GetTopFeedPostsParams{}), []string{"did:plc:a/1", "did:plc:b/1"})
+
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		for _, e := range []store.Engagement{
+			like("did:plc:x", "l", "", ""),
+			// Deleting a record again, or one that wasn't counted, is
+			// fine.
+			like("did:plc:x", "l", "", ""),
+			like("did:plc:x", "m", "", ""),
+			{Did: "did:plc:x", Collection: store.RepostCollection, Rkey: "r"},
+		} {
+			if err := w.DeleteEngagement(ctx, e.Did, e.Collection, e.Rkey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// Deletes lower the counts but not the peak.
+	check(t, "counts after deletes", counts(t, st), []string{"did:plc:b/1 0/0/0", "did:plc:a/1 1/0/3"})
+
+	// The ignored like stays uncounted once its post is in a feed.
+	addPosts(t, st, store.FeedPost{TimeUs: 30, Did: "did:plc:c", Rkey: "1"})
+	check(t, "counts of a post added later", counts(t, st), []string{"did:plc:c/1 0/0/0", "did:plc:b/1 0/0/0", "did:plc:a/1 1/0/3"})
+}
+
+func testPersonalization(t *testing.T, st store.Store) {
+	const viewer = "did:plc:viewer"
+	// The older a post, the more it has been liked, so newest and top
+	// order differ.
+	authors := []string{"did:plc:blocked", "did:plc:blocker", "did:plc:followed", "did:plc:followed-blocker", "did:plc:other"}
+	for i, did := range authors {
+		addPosts(t, st, store.FeedPost{TimeUs: int64(i+1) * 10, Did: did, Rkey: "1"})
+	}
+	block := store.GraphEdge{Did: viewer, Collection: store.BlockCollection, Rkey: "b", SubjectDid: "did:plc:blocked"}
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		for i, did := range authors {
+			for j := range len(authors) - i {
+				if err := w.AddEngagement(ctx, like(fmt.Sprintf("did:plc:fan%d", j), fmt.Sprint(i), did, "1")); err != nil {
+					return err
+				}
+			}
+		}
+		for _, edge := range []store.GraphEdge{
+			block,
+			{Did: "did:plc:blocker", Collection: store.BlockCollection, Rkey: "b", SubjectDid: viewer},
+			{Did: viewer, Collection: store.FollowCollection, Rkey: "f1", SubjectDid: "did:plc:followed"},
+			{Did: viewer, Collection: store.FollowCollection, Rkey: "f2", SubjectDid: "did:plc:followed-blocker"},
+			{Did: "did:plc:followed-blocker", Collection: store.BlockCollection, Rkey: "b", SubjectDid: viewer},
+			// Blocks that don't involve the viewer don't matter.
+			{Did: "did:plc:other", Collection: store.BlockCollection, Rkey: "b", SubjectDid: "did:plc:followed"},
+		} {
+			if err := w.AddGraphEdge(ctx, edge); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	all := []string{"did:plc:other/1", "did:plc:followed-blocker/1", "did:plc:followed/1", "did:plc:blocker/1", "did:plc:blocked/1"}
+	for _, tt := range []struct {
+		name                       string
+		hideBlocked, followingOnly bool
+		want                       []string
+	}{
+		{"unpersonalized", false, false, all},
+		{"hiding blocks", true, false, []string{"did:plc:other/1", "did:plc:followed/1"}},
+		{"following only", false, true, []string{"did:plc:followed-blocker/1", "did:plc:followed/1"}},
+		{"following only hiding blocks", true, true, []string{"did:plc:followed/1"}},
+	} {
+		check(t, tt.name+" posts", newest(t, st, store.GetFeedPostsParams{Viewer: viewer, HideBlocked: tt.hideBlocked, FollowingOnly: tt.followingOnly}), tt.want)
+		wantTop := slices.Clone(tt.want)
+		slices.Reverse(wantTop)
+		check(t, tt.name+" top posts", top(t, st, store.GetTopFeedPostsParams{Viewer: viewer, HideBlocked: tt.hideBlocked, FollowingOnly: tt.followingOnly}), wantTop)
+	}
+	// The limit applies after leaving posts out.
+	check(t, "first top post following only", top(t, st, store.GetTopFeedPostsParams{Viewer: viewer, FollowingOnly: true, Limit: 1}),
+		[]string{"did:plc:followed/1"})
+	// Without a viewer there is nobody to hide posts from.
+	check(t, "posts hiding blocks without a viewer", newest(t, st, store.GetFeedPostsParams{HideBlocked: true}), all)
+
+	write(t, st, func(ctx context.Context, w store.Writer) error {
+		return w.DeleteGraphEdge(ctx, block.Did, block.Collection, block.Rkey)
+	})
+	check(t, "posts hiding blocks after an unblock", newest(t, st, store.GetFeedPostsParams{Viewer: viewer, HideBlocked: true}),
+		[]string{"did:plc:other/1", "did:plc:followed/1", "did:plc:blocked/1"})
+}